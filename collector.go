@@ -2,94 +2,251 @@ package pgxprom
 
 import (
 	"context"
-	"regexp"
+	"errors"
+	"fmt"
+	"maps"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var _ prometheus.Collector = (*PoolCollector)(nil)
+var (
+	_ pgx.QueryTracer       = (*PoolCollector)(nil)
+	_ pgxpool.AcquireTracer = (*PoolCollector)(nil)
+	_ pgxpool.ReleaseTracer = (*PoolCollector)(nil)
+	_ prometheus.Collector  = (*PoolCollector)(nil)
+)
 
 // PoolCollector is a Prometheus pool collector for pgx metrics.
 type PoolCollector struct {
-	mu                      sync.RWMutex
-	acquireConns            *prometheus.Desc
-	canceledAcquiresTotal   *prometheus.Desc
-	constructingConns       *prometheus.Desc
-	emptyAcquiresTotal      *prometheus.Desc
-	idleConns               *prometheus.Desc
-	maxConns                *prometheus.Desc
-	totalConns              *prometheus.Desc
-	newConnectionsTotal     *prometheus.Desc
+	mu                       sync.RWMutex
+	acquiresTotal            *prometheus.Desc
+	acquireDurationTotal     *prometheus.Desc
+	acquireConns             *prometheus.Desc
+	canceledAcquiresTotal    *prometheus.Desc
+	constructingConns        *prometheus.Desc
+	emptyAcquiresTotal       *prometheus.Desc
+	emptyAcquireWaitTotal    *prometheus.Desc
+	idleConns                *prometheus.Desc
+	maxConns                 *prometheus.Desc
+	totalConns               *prometheus.Desc
+	newConnectionsTotal      *prometheus.Desc
 	maxLifetimeDestroysTotal *prometheus.Desc
-	maxIdleDestroysTotal    *prometheus.Desc
-	pools                   []*pgxpool.Pool
+	maxIdleDestroysTotal     *prometheus.Desc
+	acquireDuration          *prometheus.HistogramVec
+	acquiresInFlight         *prometheus.GaugeVec
+	holdDuration             *prometheus.HistogramVec
+	labels                   []string
+	poolLabels               []string
+	pools                    []*poolEntry
+	held                     sync.Map
+}
+
+// poolEntry is a pool together with the values of its identity labels.
+type poolEntry struct {
+	pool   *pgxpool.Pool
+	labels []string
+}
+
+// heldConn is a connection that has been acquired and not yet released.
+type heldConn struct {
+	entry      *poolEntry
+	acquiredAt time.Time
 }
 
 // NewPoolCollector returns a new collector.
-func NewPoolCollector() *PoolCollector {
-	labels := []string{"database"}
+func NewPoolCollector(options ...Option) (*PoolCollector, error) {
+	config, err := newConfig(kindPool, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := append(config.labels(labelDatabase, labelPool), config.poolLabels...)
+	if err := config.validate(append(config.labels(labelOutcome), labels...)); err != nil {
+		return nil, err
+	}
 
-	fqdn := func(v string) string {
-		return prometheus.BuildFQName("pgx", "pool", v)
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(config.fqName(name), help, labels, config.constLabels)
 	}
 
 	return &PoolCollector{
-		acquireConns: prometheus.NewDesc(fqdn("acquire_connections"),
-			"Number of connections currently in the process of being acquired.", labels, nil),
-		canceledAcquiresTotal: prometheus.NewDesc(fqdn("canceled_acquires_total"),
-			"Total number of connection acquires that were canceled.", labels, nil),
-		constructingConns: prometheus.NewDesc(fqdn("constructing_connections"),
-			"Number of connections currently in the process of being constructed.", labels, nil),
-		emptyAcquiresTotal: prometheus.NewDesc(fqdn("empty_acquires_total"),
-			"Total number of connection acquires that waited on an empty pool.", labels, nil),
-		idleConns: prometheus.NewDesc(fqdn("idle_connections"),
-			"Number of idle connections in the pool.", labels, nil),
-		maxConns: prometheus.NewDesc(fqdn("max_connections"),
-			"Maximum number of connections allowed in the pool.", labels, nil),
-		totalConns: prometheus.NewDesc(fqdn("total_connections"),
-			"Total number of connections in the pool.", labels, nil),
-		newConnectionsTotal: prometheus.NewDesc(fqdn("new_connections_total"),
-			"Total number of new connections created.", labels, nil),
-		maxLifetimeDestroysTotal: prometheus.NewDesc(fqdn("max_lifetime_destroys_total"),
-			"Total number of connections destroyed due to MaxLifetime.", labels, nil),
-		maxIdleDestroysTotal: prometheus.NewDesc(fqdn("max_idle_destroys_total"),
-			"Total number of connections destroyed due to MaxIdleTime.", labels, nil),
-	}
-}
-
-// Add appends the pool to the collector.
-func (p *PoolCollector) Add(pool *pgxpool.Pool) {
+		acquiresTotal: desc("acquires_total",
+			"Total number of successful connection acquires."),
+		acquireDurationTotal: desc("acquire_duration_seconds_total",
+			"Total time spent on successful connection acquires."),
+		acquireConns: desc("acquire_connections",
+			"Number of connections currently in the process of being acquired."),
+		canceledAcquiresTotal: desc("canceled_acquires_total",
+			"Total number of connection acquires that were canceled."),
+		constructingConns: desc("constructing_connections",
+			"Number of connections currently in the process of being constructed."),
+		emptyAcquiresTotal: desc("empty_acquires_total",
+			"Total number of connection acquires that waited on an empty pool."),
+		emptyAcquireWaitTotal: desc("empty_acquire_wait_seconds_total",
+			"Total time spent waiting on an empty pool by successful connection acquires."),
+		idleConns: desc("idle_connections",
+			"Number of idle connections in the pool."),
+		maxConns: desc("max_connections",
+			"Maximum number of connections allowed in the pool."),
+		totalConns: desc("total_connections",
+			"Total number of connections in the pool."),
+		newConnectionsTotal: desc("new_connections_total",
+			"Total number of new connections created."),
+		maxLifetimeDestroysTotal: desc("max_lifetime_destroys_total",
+			"Total number of connections destroyed due to MaxLifetime."),
+		maxIdleDestroysTotal: desc("max_idle_destroys_total",
+			"Total number of connections destroyed due to MaxIdleTime."),
+		acquireDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "acquire_duration_seconds",
+				Help:        "Time taken to acquire a connection from the pool.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			append(slices.Clone(labels), config.labels(labelOutcome)...),
+		),
+		acquiresInFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "acquires_in_flight",
+				Help:        "Number of connection acquires currently in progress.",
+				ConstLabels: config.constLabels,
+			},
+			labels,
+		),
+		holdDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "connection_hold_duration_seconds",
+				Help:        "Time a connection was held between acquire and release.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			labels,
+		),
+		labels:     labels,
+		poolLabels: config.poolLabels,
+	}, nil
+}
+
+// Add appends the pool to the collector. The pool is named after its
+// database.
+func (p *PoolCollector) Add(pool *pgxpool.Pool) error {
+	return p.AddNamed(pool, pool.Config().ConnConfig.Database)
+}
+
+// AddNamed appends the pool to the collector under the given name.
+func (p *PoolCollector) AddNamed(pool *pgxpool.Pool, name string) error {
+	return p.AddWithLabels(pool, name, nil)
+}
+
+// AddWithLabels appends the pool to the collector under the given name. The
+// labels provide the values of the labels configured with WithPoolLabels;
+// missing labels are left empty.
+func (p *PoolCollector) AddWithLabels(pool *pgxpool.Pool, name string, labels prometheus.Labels) error {
+	for key := range labels {
+		if !slices.Contains(p.poolLabels, key) {
+			return fmt.Errorf("unknown pool label %q", key)
+		}
+	}
+
+	entry := &poolEntry{
+		pool:   pool,
+		labels: []string{pool.Config().ConnConfig.Database, name},
+	}
+
+	for _, key := range p.poolLabels {
+		entry.labels = append(entry.labels, labels[key])
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.pools = append(p.pools, pool)
+
+	for _, elem := range p.pools {
+		if elem.pool == pool {
+			return fmt.Errorf("pool %q is already added", name)
+		}
+
+		if slices.Equal(elem.labels, entry.labels) {
+			return fmt.Errorf("pool %q has the same labels as an added pool", name)
+		}
+	}
+
+	p.pools = append(p.pools, entry)
+	return nil
 }
 
 // Remove removes the pool from the collector.
 func (p *PoolCollector) Remove(pool *pgxpool.Pool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.pools = slices.DeleteFunc(p.pools, func(elem *pgxpool.Pool) bool {
-		return pool == elem
+	p.pools = slices.DeleteFunc(p.pools, func(elem *poolEntry) bool {
+		if pool != elem.pool {
+			return false
+		}
+
+		labels := make(prometheus.Labels, len(p.labels))
+		for index, name := range p.labels {
+			labels[name] = elem.labels[index]
+		}
+
+		p.acquireDuration.DeletePartialMatch(labels)
+		p.acquiresInFlight.DeletePartialMatch(labels)
+		p.holdDuration.DeletePartialMatch(labels)
+		return true
 	})
+
+	p.held.Range(func(key, value any) bool {
+		if value.(*heldConn).entry.pool == pool {
+			p.held.Delete(key)
+		}
+		return true
+	})
+}
+
+// entry returns the entry of the pool or nil if the pool is not added.
+func (p *PoolCollector) entry(pool *pgxpool.Pool) *poolEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, entry := range p.pools {
+		if entry.pool == pool {
+			return entry
+		}
+	}
+
+	return nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (p *PoolCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- p.acquiresTotal
+	descs <- p.acquireDurationTotal
 	descs <- p.acquireConns
 	descs <- p.canceledAcquiresTotal
 	descs <- p.constructingConns
 	descs <- p.emptyAcquiresTotal
+	descs <- p.emptyAcquireWaitTotal
 	descs <- p.idleConns
 	descs <- p.maxConns
 	descs <- p.totalConns
 	descs <- p.newConnectionsTotal
 	descs <- p.maxLifetimeDestroysTotal
 	descs <- p.maxIdleDestroysTotal
+	p.acquireDuration.Describe(descs)
+	p.acquiresInFlight.Describe(descs)
+	p.holdDuration.Describe(descs)
 }
 
 // Collect implements the prometheus.Collector interface.
@@ -97,16 +254,19 @@ func (p *PoolCollector) Collect(metrics chan<- prometheus.Metric) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	for _, pool := range p.pools {
+	for _, entry := range p.pools {
 		var (
-			stats  = pool.Stat()
-			labels = []string{pool.Config().ConnConfig.Database}
+			stats  = entry.pool.Stat()
+			labels = entry.labels
 		)
 
+		metrics <- prometheus.MustNewConstMetric(p.acquiresTotal, prometheus.CounterValue, float64(stats.AcquireCount()), labels...)
+		metrics <- prometheus.MustNewConstMetric(p.acquireDurationTotal, prometheus.CounterValue, stats.AcquireDuration().Seconds(), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.acquireConns, prometheus.GaugeValue, float64(stats.AcquiredConns()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.canceledAcquiresTotal, prometheus.CounterValue, float64(stats.CanceledAcquireCount()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.constructingConns, prometheus.GaugeValue, float64(stats.ConstructingConns()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.emptyAcquiresTotal, prometheus.CounterValue, float64(stats.EmptyAcquireCount()), labels...)
+		metrics <- prometheus.MustNewConstMetric(p.emptyAcquireWaitTotal, prometheus.CounterValue, stats.EmptyAcquireWaitTime().Seconds(), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.idleConns, prometheus.GaugeValue, float64(stats.IdleConns()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.maxConns, prometheus.GaugeValue, float64(stats.MaxConns()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.totalConns, prometheus.GaugeValue, float64(stats.TotalConns()), labels...)
@@ -114,85 +274,611 @@ func (p *PoolCollector) Collect(metrics chan<- prometheus.Metric) {
 		metrics <- prometheus.MustNewConstMetric(p.maxLifetimeDestroysTotal, prometheus.CounterValue, float64(stats.MaxLifetimeDestroyCount()), labels...)
 		metrics <- prometheus.MustNewConstMetric(p.maxIdleDestroysTotal, prometheus.CounterValue, float64(stats.MaxIdleDestroyCount()), labels...)
 	}
+
+	p.acquireDuration.Collect(metrics)
+	p.acquiresInFlight.Collect(metrics)
+	p.holdDuration.Collect(metrics)
+}
+
+// TraceQueryStart implements pgx.QueryTracer. It does nothing; it only allows
+// the collector to be used as ConnConfig.Tracer, alone or combined with a
+// QueryCollector via multitracer.New, so that pgxpool picks up its pool tracers.
+func (p *PoolCollector) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+// TraceQueryEnd implements pgx.QueryTracer. It does nothing.
+func (p *PoolCollector) TraceQueryEnd(_ context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {}
+
+// TraceAcquireStart implements pgxpool.AcquireTracer. Acquires from pools that
+// have not been added to the collector are not recorded.
+func (p *PoolCollector) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	entry := p.entry(pool)
+	if entry == nil {
+		return ctx
+	}
+
+	p.acquiresInFlight.WithLabelValues(entry.labels...).Inc()
+
+	return context.WithValue(ctx, TraceAcquireKey, &TraceAcquireData{
+		StartedAt: time.Now(),
+		Labels:    entry.labels,
+		entry:     entry,
+	})
+}
+
+// TraceAcquireEnd implements pgxpool.AcquireTracer.
+func (p *PoolCollector) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, args pgxpool.TraceAcquireEndData) {
+	data, ok := ctx.Value(TraceAcquireKey).(*TraceAcquireData)
+	if !ok {
+		return
+	}
+
+	outcome := "ok"
+	switch {
+	case errors.Is(args.Err, context.Canceled), errors.Is(args.Err, context.DeadlineExceeded):
+		outcome = "canceled"
+	case args.Err != nil:
+		outcome = "error"
+	}
+
+	p.acquiresInFlight.WithLabelValues(data.Labels...).Dec()
+	p.acquireDuration.WithLabelValues(append(slices.Clone(data.Labels), outcome)...).Observe(time.Since(data.StartedAt).Seconds())
+
+	if args.Err == nil && args.Conn != nil {
+		p.held.Store(args.Conn, &heldConn{
+			entry:      data.entry,
+			acquiredAt: time.Now(),
+		})
+	}
+}
+
+// TraceRelease implements pgxpool.ReleaseTracer. Only connections whose
+// acquire was traced by the collector are recorded. pgxpool does not trace
+// the release of a hijacked connection, so its hold time is never observed
+// and the collector keeps tracking it until its pool is removed.
+func (p *PoolCollector) TraceRelease(_ *pgxpool.Pool, args pgxpool.TraceReleaseData) {
+	value, ok := p.held.LoadAndDelete(args.Conn)
+	if !ok {
+		return
+	}
+
+	held := value.(*heldConn)
+	p.holdDuration.WithLabelValues(held.entry.labels...).Observe(time.Since(held.acquiredAt).Seconds())
 }
 
 var (
 	_ pgx.QueryTracer      = (*QueryCollector)(nil)
 	_ pgx.BatchTracer      = (*QueryCollector)(nil)
+	_ pgx.CopyFromTracer   = (*QueryCollector)(nil)
+	_ pgx.PrepareTracer    = (*QueryCollector)(nil)
+	_ pgx.ConnectTracer    = (*QueryCollector)(nil)
 	_ prometheus.Collector = (*QueryCollector)(nil)
 )
 
 // QueryCollector is a Prometheus query collector for pgx metrics.
 type QueryCollector struct {
-	requestTotal *prometheus.CounterVec
-	errorsTotal  *prometheus.CounterVec
-	duration     *prometheus.HistogramVec
+	requestTotal     *prometheus.CounterVec
+	errorsTotal      *prometheus.CounterVec
+	violationsTotal  *prometheus.CounterVec
+	duration         observerVec
+	rows             *prometheus.HistogramVec
+	batchDuration    *prometheus.HistogramVec
+	batchSize        *prometheus.HistogramVec
+	copyRequestTotal *prometheus.CounterVec
+	copyErrorsTotal  *prometheus.CounterVec
+	copyDuration     *prometheus.HistogramVec
+	copyRows         *prometheus.HistogramVec
+	copyRowsTotal    *prometheus.CounterVec
+	prepareTotal     *prometheus.CounterVec
+	prepareErrors    *prometheus.CounterVec
+	prepareDuration  *prometheus.HistogramVec
+	reprepareTotal   *prometheus.CounterVec
+	statementCache   *prometheus.CounterVec
+	connectDuration  *prometheus.HistogramVec
+	connectErrors    *prometheus.CounterVec
+	inFlight         *prometheus.GaugeVec
+	oldestInFlight   *prometheus.Desc
+	stuckTotal       *prometheus.CounterVec
+	droppedTotal     *prometheus.CounterVec
+	contextLabels    []string
+	commentLabels    []string
+	statementLabels  bool
+	queryKindLabel   bool
+	statements       *lru[[]string]
+	namer            OperationNamer
+	classify         ErrorClassifier
+	extract          ExemplarExtractor
+	constraints      *limiter
+	guard            *guard
+	requests         *inflight
+	watchdog         *WatchdogConfig
+	labelNames       []string
+	series           *series
+	databases        *series
+	ttl              time.Duration
+	stop             chan struct{}
+	wg               sync.WaitGroup
+	closeOnce        sync.Once
 }
 
 // NewQueryCollector creates a new QueryCollector.
-func NewQueryCollector() *QueryCollector {
-	labels := []string{"database", "db_operation"}
+func NewQueryCollector(options ...Option) (*QueryCollector, error) {
+	config, err := newConfig(kindQuery, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := config.labels(labelDatabase, labelOperation)
+	if config.statementLabels {
+		labels = append(labels, config.labels(labelStatement, labelTable)...)
+	}
+	if config.queryKindLabel {
+		labels = append(labels, config.labels(labelQueryKind)...)
+	}
+	labels = append(labels, config.contextLabels...)
+	labels = append(labels, config.commentLabels...)
+	if err := config.validate(labels); err != nil {
+		return nil, err
+	}
+
+	errorLabels := append(slices.Clone(labels), config.labels(labelErrorClass)...)
+	if err := config.validate(errorLabels); err != nil {
+		return nil, err
+	}
+
+	violationLabels := config.labels(labelDatabase, labelConstraint, labelTable, labelSQLState)
+	if err := config.validate(violationLabels); err != nil {
+		return nil, err
+	}
+
+	rowLabels := append(slices.Clone(labels), config.labels(labelCommand)...)
+	if err := config.validate(rowLabels); err != nil {
+		return nil, err
+	}
+
+	batchLabels := config.labels(labelDatabase)
+
+	copyLabels := config.labels(labelDatabase, labelTable)
+	if err := config.validate(copyLabels); err != nil {
+		return nil, err
+	}
+
+	prepareLabels := config.labels(labelDatabase)
+	cacheLabels := config.labels(labelDatabase, labelResult)
+	if err := config.validate(cacheLabels); err != nil {
+		return nil, err
+	}
+
+	// the counter only exists when a limit is set. Its name does not depend
+	// on the namespace, so the collector label tells collectors apart.
+	var droppedTotal *prometheus.CounterVec
+	if config.labelLimit > 0 || len(config.labelLimits) > 0 || config.seriesLimit > 0 {
+		if err := config.validate([]string{labelCollector, labelLabel}); err != nil {
+			return nil, err
+		}
+
+		constLabels := maps.Clone(config.constLabels)
+		if constLabels == nil {
+			constLabels = prometheus.Labels{}
+		}
+		constLabels[labelCollector] = config.prefix()
+
+		droppedTotal = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   "pgxprom",
+				Name:        "dropped_label_values_total",
+				Help:        "Total number of label values replaced by the overflow value because a cardinality limit was reached.",
+				ConstLabels: constLabels,
+			},
+			[]string{labelLabel},
+		)
+	}
+
+	connectLabels := config.labels(labelHost)
+	connectErrorLabels := config.labels(labelHost, labelErrorClass)
+	if err := config.validate(connectErrorLabels); err != nil {
+		return nil, err
+	}
+
+	// the CopyFrom table and the connect host follow the request labels, see
+	// QueryCollector.table and QueryCollector.host
+	guarded := append(slices.Clone(labels), copyLabels[1], connectLabels[0])
+
+	guard, err := newGuard(guarded, config.labelLimits, config.labelLimit, config.seriesLimit, droppedTotal)
+	if err != nil {
+		return nil, err
+	}
 
-	return &QueryCollector{
+	duration, err := newDurationVec(config, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	collector := &QueryCollector{
 		requestTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: "pgx",
-				Subsystem: "conn",
-				Name:      "requests_total",
-				Help:      "Total number of database requests.",
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "requests_total",
+				Help:        "Total number of database requests.",
+				ConstLabels: config.constLabels,
 			},
 			labels,
 		),
 		errorsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: "pgx",
-				Subsystem: "conn",
-				Name:      "request_errors_total",
-				Help:      "Total number of database request errors.",
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "request_errors_total",
+				Help:        "Total number of database request errors by error class.",
+				ConstLabels: config.constLabels,
 			},
-			labels,
+			errorLabels,
 		),
-		duration: prometheus.NewHistogramVec(
+		violationsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "constraint_violations_total",
+				Help:        "Total number of integrity constraint violations by constraint and table.",
+				ConstLabels: config.constLabels,
+			},
+			violationLabels,
+		),
+		duration: duration,
+		rows: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "request_rows",
+				Help:        "Number of rows affected or returned by a database request.",
+				Buckets:     config.rowBuckets,
+				ConstLabels: config.constLabels,
+			},
+			rowLabels,
+		),
+		batchDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "batch_duration_seconds",
+				Help:        "Time taken to complete a batch.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			batchLabels,
+		),
+		batchSize: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Namespace: "pgx",
-				Subsystem: "conn",
-				Name:      "request_duration_seconds",
-				Help:      "Time taken to complete a database request.",
-				Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 10},
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "batch_size",
+				Help:        "Number of queries in a batch.",
+				Buckets:     []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+				ConstLabels: config.constLabels,
+			},
+			batchLabels,
+		),
+		copyRequestTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "copy_from_requests_total",
+				Help:        "Total number of CopyFrom requests.",
+				ConstLabels: config.constLabels,
+			},
+			copyLabels,
+		),
+		copyErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "copy_from_errors_total",
+				Help:        "Total number of CopyFrom request errors.",
+				ConstLabels: config.constLabels,
+			},
+			copyLabels,
+		),
+		copyDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "copy_from_duration_seconds",
+				Help:        "Time taken to complete a CopyFrom request.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			copyLabels,
+		),
+		copyRows: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "copy_from_rows",
+				Help:        "Number of rows copied by a CopyFrom request.",
+				Buckets:     config.rowBuckets,
+				ConstLabels: config.constLabels,
+			},
+			copyLabels,
+		),
+		copyRowsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "copy_from_rows_total",
+				Help:        "Total number of rows copied by CopyFrom requests.",
+				ConstLabels: config.constLabels,
+			},
+			copyLabels,
+		),
+		prepareTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "prepares_total",
+				Help:        "Total number of prepare requests sent to the server.",
+				ConstLabels: config.constLabels,
+			},
+			prepareLabels,
+		),
+		prepareErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "prepare_errors_total",
+				Help:        "Total number of prepare request errors.",
+				ConstLabels: config.constLabels,
+			},
+			prepareLabels,
+		),
+		prepareDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "prepare_duration_seconds",
+				Help:        "Time taken to complete a prepare request sent to the server.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			prepareLabels,
+		),
+		reprepareTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "reprepares_total",
+				Help:        "Total number of explicit prepare requests for a statement already prepared on the connection, which skip the server.",
+				ConstLabels: config.constLabels,
+			},
+			prepareLabels,
+		),
+		statementCache: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "statement_cache_total",
+				Help:        "Total number of statement cache lookups of queries by result (hit or miss).",
+				ConstLabels: config.constLabels,
+			},
+			cacheLabels,
+		),
+		connectDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "connect_duration_seconds",
+				Help:        "Time taken to establish a database connection.",
+				Buckets:     config.buckets,
+				ConstLabels: config.constLabels,
+			},
+			connectLabels,
+		),
+		connectErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "connect_errors_total",
+				Help:        "Total number of failed connection attempts by error class (network, tls, auth or other).",
+				ConstLabels: config.constLabels,
+			},
+			connectErrorLabels,
+		),
+		inFlight: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "requests_in_flight",
+				Help:        "Number of database requests currently running.",
+				ConstLabels: config.constLabels,
+			},
+			labels,
+		),
+		oldestInFlight: prometheus.NewDesc(config.fqName("oldest_request_in_flight_seconds"),
+			"Age of the oldest database request that is still running.", labels, config.constLabels),
+		droppedTotal: droppedTotal,
+		stuckTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        "stuck_requests_total",
+				Help:        "Total number of database requests reported by the watchdog as running longer than their threshold.",
+				ConstLabels: config.constLabels,
 			},
 			labels,
 		),
+		contextLabels:   config.contextLabels,
+		commentLabels:   config.commentLabels,
+		namer:           config.namer,
+		statementLabels: config.statementLabels,
+		queryKindLabel:  config.queryKindLabel,
+		statements:      newLRU[[]string](statementCacheSize),
+		classify:        config.classify,
+		extract:         config.exemplar,
+		constraints:     newLimiter(config.constraintLimit),
+		guard:           guard,
+		requests:        newInflight(),
+		watchdog:        config.watchdog,
+		labelNames:      labels,
+		series:          newSeries(),
+		databases:       newSeries(),
+		ttl:             config.seriesTTL,
+		stop:            make(chan struct{}),
+	}
+
+	if collector.watchdog != nil {
+		collector.wg.Add(1)
+		go collector.watch()
 	}
+
+	if collector.ttl > 0 {
+		collector.wg.Add(1)
+		go collector.janitor()
+	}
+
+	return collector, nil
+}
+
+// observerVec is a vector of observers, such as a HistogramVec or a
+// SummaryVec.
+type observerVec interface {
+	prometheus.Collector
+	WithLabelValues(values ...string) prometheus.Observer
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// newDurationVec returns the vector of the request duration: a histogram,
+// which may be native, or a summary.
+func newDurationVec(config *config, labels []string) (observerVec, error) {
+	const (
+		name = "request_duration_seconds"
+		help = "Time taken to complete a database request."
+	)
+
+	if config.summary != nil {
+		if config.nativeHistogram != nil {
+			return nil, fmt.Errorf("native histogram and summary are mutually exclusive")
+		}
+
+		return prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Namespace:   config.namespace,
+				Subsystem:   config.subsystem,
+				Name:        name,
+				Help:        help,
+				Objectives:  config.summary.Objectives,
+				MaxAge:      config.summary.MaxAge,
+				AgeBuckets:  config.summary.AgeBuckets,
+				ConstLabels: config.constLabels,
+			},
+			labels,
+		), nil
+	}
+
+	opts := prometheus.HistogramOpts{
+		Namespace:   config.namespace,
+		Subsystem:   config.subsystem,
+		Name:        name,
+		Help:        help,
+		Buckets:     config.buckets,
+		ConstLabels: config.constLabels,
+	}
+
+	if config.nativeHistogram != nil {
+		opts.NativeHistogramBucketFactor = config.nativeHistogram.BucketFactor
+		opts.NativeHistogramMaxBucketNumber = config.nativeHistogram.MaxBuckets
+
+		if !config.nativeHistogram.Classic {
+			opts.Buckets = nil
+		}
+	}
+
+	return prometheus.NewHistogramVec(opts, labels), nil
+}
+
+// Close stops the watchdog and the series janitor of the collector, if any.
+// The collector keeps recording requests after it is closed.
+func (q *QueryCollector) Close() {
+	q.closeOnce.Do(func() {
+		close(q.stop)
+	})
+
+	q.wg.Wait()
 }
 
 // Collect implements prometheus.Collector.
 func (q *QueryCollector) Collect(metrics chan<- prometheus.Metric) {
 	q.requestTotal.Collect(metrics)
 	q.errorsTotal.Collect(metrics)
+	q.violationsTotal.Collect(metrics)
 	q.duration.Collect(metrics)
+	q.rows.Collect(metrics)
+	q.batchDuration.Collect(metrics)
+	q.batchSize.Collect(metrics)
+	q.copyRequestTotal.Collect(metrics)
+	q.copyErrorsTotal.Collect(metrics)
+	q.copyDuration.Collect(metrics)
+	q.copyRows.Collect(metrics)
+	q.copyRowsTotal.Collect(metrics)
+	q.prepareTotal.Collect(metrics)
+	q.prepareErrors.Collect(metrics)
+	q.prepareDuration.Collect(metrics)
+	q.reprepareTotal.Collect(metrics)
+	q.statementCache.Collect(metrics)
+	q.connectDuration.Collect(metrics)
+	q.connectErrors.Collect(metrics)
+	q.inFlight.Collect(metrics)
+	q.stuckTotal.Collect(metrics)
+	if q.droppedTotal != nil {
+		q.droppedTotal.Collect(metrics)
+	}
+
+	now := time.Now()
+	for _, r := range q.requests.oldest() {
+		metrics <- prometheus.MustNewConstMetric(q.oldestInFlight, prometheus.GaugeValue, now.Sub(r.startedAt).Seconds(), r.labels...)
+	}
 }
 
 // Describe implements prometheus.Collector.
 func (q *QueryCollector) Describe(descs chan<- *prometheus.Desc) {
 	q.requestTotal.Describe(descs)
 	q.errorsTotal.Describe(descs)
+	q.violationsTotal.Describe(descs)
 	q.duration.Describe(descs)
+	q.rows.Describe(descs)
+	q.batchDuration.Describe(descs)
+	q.batchSize.Describe(descs)
+	q.copyRequestTotal.Describe(descs)
+	q.copyErrorsTotal.Describe(descs)
+	q.copyDuration.Describe(descs)
+	q.copyRows.Describe(descs)
+	q.copyRowsTotal.Describe(descs)
+	q.prepareTotal.Describe(descs)
+	q.prepareErrors.Describe(descs)
+	q.prepareDuration.Describe(descs)
+	q.reprepareTotal.Describe(descs)
+	q.statementCache.Describe(descs)
+	q.connectDuration.Describe(descs)
+	q.connectErrors.Describe(descs)
+	q.inFlight.Describe(descs)
+	q.stuckTotal.Describe(descs)
+	if q.droppedTotal != nil {
+		q.droppedTotal.Describe(descs)
+	}
+	descs <- q.oldestInFlight
 }
 
 // TraceQueryStart implements pgx.QueryTracer.
 func (q *QueryCollector) TraceQueryStart(ctx context.Context, conn *pgx.Conn, args pgx.TraceQueryStartData) context.Context {
-	labels := prometheus.Labels{
-		"database":     conn.Config().Database,
-		"db_operation": q.name(args.SQL),
-	}
-
-	q.requestTotal.With(labels).Inc()
-
-	return context.WithValue(ctx, TraceQueryKey, &TraceQueryData{
+	data := &TraceQueryData{
 		StartedAt: time.Now(),
 		SQL:       args.SQL,
 		Args:      args.Args,
-	})
+	}
+
+	data.request = q.start(ctx, conn, args.SQL, data.StartedAt)
+
+	return context.WithValue(ctx, TraceQueryKey, data)
 }
 
 // TraceQueryEnd implements pgx.QueryTracer.
@@ -202,47 +888,154 @@ func (q *QueryCollector) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, args
 		return
 	}
 
-	labels := prometheus.Labels{
-		"database":     conn.Config().Database,
-		"db_operation": q.name(data.SQL),
-	}
+	labels := data.request.labels
+	q.finish(data.request)
 
 	if args.Err != nil {
-		q.errorsTotal.With(labels).Inc()
+		q.error(ctx, labels, args.Err)
+	} else {
+		q.observeRows(labels, args.CommandTag)
 	}
 
-	q.duration.With(labels).Observe(time.Since(data.StartedAt).Seconds())
+	q.observe(ctx, q.duration.WithLabelValues(labels...), time.Since(data.StartedAt).Seconds())
+
+	if mode, ok := queryExecMode(conn, data.Args); ok && (mode == pgx.QueryExecModeCacheStatement || mode == pgx.QueryExecModeCacheDescribe) {
+		result := "hit"
+		if data.prepared {
+			result = "miss"
+		}
+
+		q.statementCache.WithLabelValues(labels[0], result).Inc()
+	}
 }
 
-// TraceBatchStart implements pgx.BatchTracer.
-func (q *QueryCollector) TraceBatchStart(ctx context.Context, conn *pgx.Conn, args pgx.TraceBatchStartData) context.Context {
-	for _, query := range args.Batch.QueuedQueries {
-		labels := prometheus.Labels{
-			"database":     conn.Config().Database,
-			"db_operation": q.name(query.SQL),
+// queryExecMode returns the mode pgx runs a query with the arguments in: that
+// of a leading QueryExecMode argument, or else the default of the connection.
+// It reports false for a query without arguments, which pgx may send with the
+// simple protocol instead.
+func queryExecMode(conn *pgx.Conn, args []any) (pgx.QueryExecMode, bool) {
+	mode := conn.Config().DefaultQueryExecMode
+	rewritten := false
+
+options:
+	for len(args) > 0 {
+		switch arg := args[0].(type) {
+		case pgx.QueryExecMode:
+			mode = arg
+		case pgx.QueryRewriter:
+			rewritten = true
+		case pgx.QueryResultFormats, pgx.QueryResultFormatsByOID:
+		default:
+			break options
 		}
 
-		q.requestTotal.With(labels).Inc()
+		args = args[1:]
 	}
 
-	return context.WithValue(ctx, TraceBatchKey, &TraceBatchData{
-		StartedAt: time.Now(),
+	return mode, rewritten || len(args) > 0
+}
+
+// start counts a request and tracks it as running.
+func (q *QueryCollector) start(ctx context.Context, conn *pgx.Conn, sql string, startedAt time.Time) *request {
+	r := &request{
+		labels:    q.labels(ctx, conn, sql),
+		startedAt: startedAt,
+		sql:       sql,
+		conn:      conn,
+	}
+
+	q.requestTotal.WithLabelValues(r.labels...).Inc()
+	q.inFlight.WithLabelValues(r.labels...).Inc()
+	q.requests.add(r)
+
+	return r
+}
+
+// finish stops tracking a running request.
+func (q *QueryCollector) finish(r *request) {
+	if q.ttl > 0 {
+		q.series.touch(r.labels, time.Now())
+	}
+
+	if q.requests.remove(r) {
+		q.inFlight.WithLabelValues(r.labels...).Dec()
+	}
+}
+
+// error records a failed request.
+func (q *QueryCollector) error(ctx context.Context, labels []string, err error) {
+	q.inc(ctx, q.errorsTotal.WithLabelValues(append(slices.Clone(labels), q.classify(err))...))
+
+	var pgErr *pgconn.PgError
+	// SQLSTATE class 23 is integrity_constraint_violation.
+	if !errors.As(err, &pgErr) || !strings.HasPrefix(pgErr.Code, "23") {
+		return
+	}
+
+	constraint, table := pgErr.ConstraintName, pgErr.TableName
+	if !q.constraints.allow(constraint + "\xff" + table) {
+		constraint, table = overflowValue, overflowValue
+	}
+
+	q.inc(ctx, q.violationsTotal.WithLabelValues(labels[0], constraint, table, pgErr.Code))
+}
+
+// observeRows records the number of rows in the command tag of a request,
+// labelled by the command verb of the tag.
+func (q *QueryCollector) observeRows(labels []string, tag pgconn.CommandTag) {
+	command, _, _ := strings.Cut(tag.String(), " ")
+	if command == "" {
+		return
+	}
+
+	q.rows.WithLabelValues(append(slices.Clone(labels), command)...).Observe(float64(tag.RowsAffected()))
+}
+
+// TraceBatchStart implements pgx.BatchTracer.
+func (q *QueryCollector) TraceBatchStart(ctx context.Context, conn *pgx.Conn, args pgx.TraceBatchStartData) context.Context {
+	now := time.Now()
+
+	data := &TraceBatchData{
+		StartedAt: now,
+		QueriedAt: now,
 		Batch:     args.Batch,
-	})
+	}
+
+	for _, query := range args.Batch.QueuedQueries {
+		data.requests = append(data.requests, q.start(ctx, conn, query.SQL, now))
+	}
+
+	return context.WithValue(ctx, TraceBatchKey, data)
 }
 
-// TraceBatchQuery implements pgx.BatchTracer.
-func (q *QueryCollector) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
-	if data.Err == nil {
+// TraceBatchQuery implements pgx.BatchTracer. The duration of a query is the
+// time since the previous result of the batch was read, or since the batch
+// started for the first query.
+func (q *QueryCollector) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, args pgx.TraceBatchQueryData) {
+	data, ok := ctx.Value(TraceBatchKey).(*TraceBatchData)
+	if !ok {
 		return
 	}
 
-	labels := prometheus.Labels{
-		"database":     conn.Config().Database,
-		"db_operation": q.name(data.SQL),
+	var labels []string
+	// results are read in the order the queries were queued
+	if len(data.requests) > 0 {
+		labels = data.requests[0].labels
+		q.finish(data.requests[0])
+		data.requests = data.requests[1:]
+	} else {
+		labels = q.labels(ctx, conn, args.SQL)
 	}
 
-	q.errorsTotal.With(labels).Inc()
+	if args.Err != nil {
+		q.error(ctx, labels, args.Err)
+	} else {
+		q.observeRows(labels, args.CommandTag)
+	}
+
+	now := time.Now()
+	q.observe(ctx, q.duration.WithLabelValues(labels...), now.Sub(data.QueriedAt).Seconds())
+	data.QueriedAt = now
 }
 
 // TraceBatchEnd implements pgx.BatchTracer.
@@ -252,23 +1045,205 @@ func (q *QueryCollector) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, args
 		return
 	}
 
-	elapsed := time.Since(data.StartedAt).Seconds()
+	// queries whose results were never read are no longer running either
+	for _, r := range data.requests {
+		q.finish(r)
+	}
+	data.requests = nil
+
+	database := q.database(conn)
+
+	q.observe(ctx, q.batchDuration.WithLabelValues(database), time.Since(data.StartedAt).Seconds())
+	q.batchSize.WithLabelValues(database).Observe(float64(data.Batch.Len()))
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer.
+func (q *QueryCollector) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, args pgx.TraceCopyFromStartData) context.Context {
+	q.copyRequestTotal.WithLabelValues(q.database(conn), q.table(args.TableName)).Inc()
+
+	return context.WithValue(ctx, TraceCopyFromKey, &TraceCopyFromData{
+		StartedAt: time.Now(),
+		TableName: args.TableName,
+	})
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer.
+func (q *QueryCollector) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, args pgx.TraceCopyFromEndData) {
+	data, ok := ctx.Value(TraceCopyFromKey).(*TraceCopyFromData)
+	if !ok {
+		return
+	}
+
+	labels := []string{q.database(conn), q.table(data.TableName)}
+
+	if args.Err != nil {
+		q.copyErrorsTotal.WithLabelValues(labels...).Inc()
+	}
+
+	q.copyDuration.WithLabelValues(labels...).Observe(time.Since(data.StartedAt).Seconds())
+
+	if args.Err == nil {
+		rows := float64(args.CommandTag.RowsAffected())
+		q.copyRows.WithLabelValues(labels...).Observe(rows)
+		q.copyRowsTotal.WithLabelValues(labels...).Add(rows)
+	}
+}
+
+// TracePrepareStart implements pgx.PrepareTracer. pgx prepares the statement
+// of a query that misses its statement cache with the context of the query,
+// which marks the lookup as a miss.
+func (q *QueryCollector) TracePrepareStart(ctx context.Context, conn *pgx.Conn, args pgx.TracePrepareStartData) context.Context {
+	if data, ok := ctx.Value(TraceQueryKey).(*TraceQueryData); ok {
+		data.prepared = true
+	}
+
+	return context.WithValue(ctx, TracePrepareKey, &TracePrepareData{
+		StartedAt: time.Now(),
+		Name:      args.Name,
+		SQL:       args.SQL,
+	})
+}
+
+// TracePrepareEnd implements pgx.PrepareTracer. pgx only traces explicit
+// calls to Conn.Prepare and statements it prepares for its statement cache;
+// queries served from the statement cache are not traced at all. A statement
+// that is already prepared under the same name does not reach the server and
+// is only counted as a re-prepare.
+func (q *QueryCollector) TracePrepareEnd(ctx context.Context, conn *pgx.Conn, args pgx.TracePrepareEndData) {
+	data, ok := ctx.Value(TracePrepareKey).(*TracePrepareData)
+	if !ok {
+		return
+	}
+
+	database := q.database(conn)
+
+	if args.AlreadyPrepared {
+		q.reprepareTotal.WithLabelValues(database).Inc()
+		return
+	}
+
+	q.prepareTotal.WithLabelValues(database).Inc()
+	if args.Err != nil {
+		q.prepareErrors.WithLabelValues(database).Inc()
+	}
+
+	q.prepareDuration.WithLabelValues(database).Observe(time.Since(data.StartedAt).Seconds())
+}
+
+// TraceConnectStart implements pgx.ConnectTracer.
+func (q *QueryCollector) TraceConnectStart(ctx context.Context, args pgx.TraceConnectStartData) context.Context {
+	return context.WithValue(ctx, TraceConnectKey, &TraceConnectData{
+		StartedAt: time.Now(),
+		Host:      args.ConnConfig.Host,
+	})
+}
+
+// TraceConnectEnd implements pgx.ConnectTracer.
+func (q *QueryCollector) TraceConnectEnd(ctx context.Context, args pgx.TraceConnectEndData) {
+	data, ok := ctx.Value(TraceConnectKey).(*TraceConnectData)
+	if !ok {
+		return
+	}
+
+	host := q.host(data.Host)
+
+	if args.Err != nil {
+		q.connectErrors.WithLabelValues(host, connectErrorClass(args.Err)).Inc()
+	}
+
+	q.connectDuration.WithLabelValues(host).Observe(time.Since(data.StartedAt).Seconds())
+}
+
+// statementCacheSize is the number of SQL texts whose statement, query kind
+// and sqlcommenter label values are cached.
+const statementCacheSize = 1000
+
+// labels returns the label values of a request: the database, the operation,
+// the statement verb, table and query kind if enabled, and the values of the
+// context and sqlcommenter labels, subject to the cardinality limits.
+func (q *QueryCollector) labels(ctx context.Context, conn *pgx.Conn, sql string) []string {
+	values := []string{conn.Config().Database, q.name(ctx, sql)}
+
+	statement := q.statement(sql)
+	values = append(values, statement[:len(statement)-len(q.commentLabels)]...)
+
+	extra, _ := ctx.Value(labelsKey).(prometheus.Labels)
+	for _, name := range q.contextLabels {
+		values = append(values, extra[name])
+	}
+
+	values = append(values, statement[len(statement)-len(q.commentLabels):]...)
 
-	for _, query := range data.Batch.QueuedQueries {
-		labels := prometheus.Labels{
-			"database":     conn.Config().Database,
-			"db_operation": q.name(query.SQL),
+	values = q.guard.apply(values)
+	if q.ttl > 0 {
+		q.series.touch(values, time.Now())
+	}
+
+	return values
+}
+
+// statement returns the label values derived from the SQL text: the statement
+// verb, table and query kind if enabled, followed by the values of the
+// sqlcommenter labels. They are cached per SQL text, so that the SQL of a
+// repeated request is not tokenized again.
+func (q *QueryCollector) statement(sql string) []string {
+	if !q.statementLabels && !q.queryKindLabel && len(q.commentLabels) == 0 {
+		return nil
+	}
+
+	if values, ok := q.statements.get(sql); ok {
+		return values
+	}
+
+	var values []string
+
+	if q.statementLabels {
+		verb, table := parseStatement(sql)
+		values = append(values, verb, table)
+	}
+
+	if q.queryKindLabel {
+		values = append(values, submatch(queryKindPattern, sql))
+	}
+
+	if len(q.commentLabels) > 0 {
+		tags := parseSQLComment(sql)
+		for _, key := range q.commentLabels {
+			values = append(values, tags[key])
 		}
+	}
+
+	return q.statements.add(sql, values)
+}
 
-		q.duration.With(labels).Observe(elapsed)
+// database returns the database label value of the connection, subject to
+// the limit of the database label.
+func (q *QueryCollector) database(conn *pgx.Conn) string {
+	database := q.guard.value(0, conn.Config().Database)
+	if q.ttl > 0 {
+		q.databases.touch([]string{database}, time.Now())
 	}
+
+	return database
 }
 
-var pattern = regexp.MustCompile(`^--\s+name:\s+(\w+)`)
+// table returns the CopyFrom table label value of the table name, subject to
+// the limit of the table label.
+func (q *QueryCollector) table(name pgx.Identifier) string {
+	return q.guard.value(len(q.labelNames), strings.Join(name, "."))
+}
+
+// host returns the connect host label value, subject to the limit of the
+// host label.
+func (q *QueryCollector) host(host string) string {
+	return q.guard.value(len(q.labelNames)+1, host)
+}
 
-func (q *QueryCollector) name(v string) string {
-	if match := pattern.FindStringSubmatch(v); len(match) == 2 {
-		return match[1]
+// name returns the operation of a request, or "unknown" when the namer cannot
+// name it.
+func (q *QueryCollector) name(ctx context.Context, v string) string {
+	if name := q.namer.Name(ctx, v); name != "" {
+		return name
 	}
 
 	return "unknown"
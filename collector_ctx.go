@@ -1,10 +1,13 @@
 package pgxprom
 
 import (
+	"context"
+	"maps"
 	"reflect"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ContextKey represents a context key.
@@ -27,6 +30,9 @@ type TraceQueryData struct {
 	StartedAt time.Time
 	SQL       string
 	Args      []any
+	request   *request
+	// prepared is set when the query prepared its statement.
+	prepared bool
 }
 
 // TraceBatchKey represents the context key of the data.
@@ -37,5 +43,79 @@ var TraceBatchKey = &ContextKey{
 // TraceBatchData represents a batch data
 type TraceBatchData struct {
 	StartedAt time.Time
+	QueriedAt time.Time
 	Batch     *pgx.Batch
+	requests  []*request
+}
+
+// TraceCopyFromKey represents the context key of the data.
+var TraceCopyFromKey = &ContextKey{
+	name: reflect.TypeOf(TraceCopyFromData{}).PkgPath(),
+}
+
+// TraceCopyFromData represents a copy from data
+type TraceCopyFromData struct {
+	StartedAt time.Time
+	TableName pgx.Identifier
+}
+
+// TracePrepareKey represents the context key of the data.
+var TracePrepareKey = &ContextKey{
+	name: reflect.TypeOf(TracePrepareData{}).PkgPath(),
+}
+
+// TracePrepareData represents a prepare data
+type TracePrepareData struct {
+	StartedAt time.Time
+	Name      string
+	SQL       string
+}
+
+// TraceConnectKey represents the context key of the data.
+var TraceConnectKey = &ContextKey{
+	name: reflect.TypeOf(TraceConnectData{}).PkgPath(),
+}
+
+// TraceConnectData represents a connect data
+type TraceConnectData struct {
+	StartedAt time.Time
+	Host      string
+}
+
+// TraceAcquireKey represents the context key of the data.
+var TraceAcquireKey = &ContextKey{
+	name: reflect.TypeOf(TraceAcquireData{}).PkgPath(),
+}
+
+// TraceAcquireData represents a connection acquire data
+type TraceAcquireData struct {
+	StartedAt time.Time
+	Labels    []string
+	entry     *poolEntry
+}
+
+// operationKey is the context key of the operation name set by WithOperation.
+var operationKey = &ContextKey{name: "operation"}
+
+// labelsKey is the context key of the labels set by WithLabels.
+var labelsKey = &ContextKey{name: "labels"}
+
+// WithOperation returns a copy of ctx that carries the db_operation label of
+// the requests run with it. With the default OperationNamer, a "-- name:"
+// comment in the SQL takes precedence over the context.
+func WithOperation(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationKey, name)
+}
+
+// WithLabels returns a copy of ctx that carries label values for the requests
+// run with it. Only labels declared with WithContextLabels are recorded; the
+// values are merged with those already in ctx.
+func WithLabels(ctx context.Context, labels prometheus.Labels) context.Context {
+	merged := prometheus.Labels{}
+	if parent, ok := ctx.Value(labelsKey).(prometheus.Labels); ok {
+		maps.Copy(merged, parent)
+	}
+	maps.Copy(merged, labels)
+
+	return context.WithValue(ctx, labelsKey, merged)
 }
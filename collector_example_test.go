@@ -22,9 +22,14 @@ func ExamplePoolCollector() {
 		panic(err)
 	}
 
-	collector := pgxprom.NewPoolCollector()
+	collector, err := pgxprom.NewPoolCollector()
+	if err != nil {
+		panic(err)
+	}
 	// register the pool
-	collector.Add(pool)
+	if err := collector.Add(pool); err != nil {
+		panic(err)
+	}
 	// register the collector
 	if err := prometheus.Register(collector); err != nil {
 		panic(err)
@@ -37,7 +42,13 @@ func ExampleQueryCollector() {
 		panic(err)
 	}
 
-	collector := pgxprom.NewQueryCollector()
+	collector, err := pgxprom.NewQueryCollector(
+		pgxprom.WithNamespace("app"),
+		pgxprom.WithBuckets(.0005, .001, .005, .01, .05, .1, .5, 1),
+	)
+	if err != nil {
+		panic(err)
+	}
 	// register the collector
 	prometheus.MustRegister(collector)
 	// attach as the pgx tracer
@@ -2,14 +2,24 @@ package pgxprom
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/jackc/pgx/v5/pgxpool"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // newPool creates a pool from PGX_DATABASE_URL, optionally attaching a tracer.
@@ -24,22 +34,369 @@ func newPool(tracer pgx.QueryTracer) (*pgxpool.Pool, string) {
 	return pool, config.ConnConfig.Database
 }
 
+// newLazyPool creates a pool that does not connect until it is used.
+func newLazyPool(url string) *pgxpool.Pool {
+	config, err := pgxpool.ParseConfig(url)
+	Expect(err).NotTo(HaveOccurred())
+	config.MaxConns = 4
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	Expect(err).NotTo(HaveOccurred())
+	return pool
+}
+
+// sampleCount returns the number of observations of a histogram.
+func sampleCount(observer prometheus.Observer) uint64 {
+	return histogram(observer).GetSampleCount()
+}
+
+// sampleSum returns the sum of observations of a histogram.
+func sampleSum(observer prometheus.Observer) float64 {
+	return histogram(observer).GetSampleSum()
+}
+
+func histogram(observer prometheus.Observer) *dto.Histogram {
+	metric := &dto.Metric{}
+	Expect(observer.(prometheus.Metric).Write(metric)).To(Succeed())
+	return metric.GetHistogram()
+}
+
+// fakeServer records what the connections of newFakeServer send.
+type fakeServer struct {
+	queries chan string
+	cancels chan uint32
+}
+
+// newFakeConn connects to a fake server that completes the startup handshake
+// and then answers every simple query with an empty result. It gives tracer
+// specs a real *pgx.Conn without requiring a database.
+func newFakeConn(database string) *pgx.Conn {
+	conn, _ := newFakeServer(database)
+	return conn
+}
+
+// newFakeServer is like newFakeConn and also returns the fake server.
+func newFakeServer(database string) (*pgx.Conn, *fakeServer) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(listener.Close)
+
+	server := &fakeServer{
+		queries: make(chan string, 100),
+		cancels: make(chan uint32, 100),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn)
+		}
+	}()
+
+	url := fmt.Sprintf("postgres://%s/%s?sslmode=disable", listener.Addr(), database)
+	conn, err := pgx.Connect(context.Background(), url)
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(conn.Close, context.Background())
+	return conn, server
+}
+
+// serve completes the startup handshake of a connection and answers simple
+// queries with an empty result, discarding everything else. Cancel requests
+// are recorded by process ID and acknowledged by closing the connection.
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	backend := pgproto3.NewBackend(conn, conn)
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+
+	if cancel, ok := msg.(*pgproto3.CancelRequest); ok {
+		s.cancels <- cancel.ProcessID
+		return
+	}
+
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: []byte{0, 0, 0, 1}})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if err := backend.Flush(); err != nil {
+		return
+	}
+
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+
+		if query, ok := msg.(*pgproto3.Query); ok {
+			s.queries <- query.String
+			backend.Send(&pgproto3.EmptyQueryResponse{})
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if err := backend.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// newPoolCollector creates a PoolCollector, failing the spec on error.
+func newPoolCollector(options ...Option) *PoolCollector {
+	collector, err := NewPoolCollector(options...)
+	Expect(err).NotTo(HaveOccurred())
+	return collector
+}
+
+// newQueryCollector creates a QueryCollector, failing the spec on error.
+func newQueryCollector(options ...Option) *QueryCollector {
+	collector, err := NewQueryCollector(options...)
+	Expect(err).NotTo(HaveOccurred())
+	return collector
+}
+
 var _ = Describe("PoolCollector", func() {
 	// -------------------------------------------------------------------------
 	Describe("NewPoolCollector", func() {
 		It("returns a non-nil collector", func() {
-			Expect(NewPoolCollector()).NotTo(BeNil())
+			Expect(newPoolCollector()).NotTo(BeNil())
 		})
 
-		It("Describe sends 10 descriptors", func() {
+		It("Describe sends 16 descriptors", func() {
 			ch := make(chan *prometheus.Desc, 20)
-			NewPoolCollector().Describe(ch)
+			newPoolCollector().Describe(ch)
 			close(ch)
-			Expect(ch).To(HaveLen(10))
+			Expect(ch).To(HaveLen(16))
 		})
 
 		It("registers on a fresh registry without error", func() {
-			Expect(prometheus.NewRegistry().Register(NewPoolCollector())).To(Succeed())
+			Expect(prometheus.NewRegistry().Register(newPoolCollector())).To(Succeed())
+		})
+
+		It("returns an error for an invalid option", func() {
+			collector, err := NewPoolCollector(WithNamespace("pgx-app"))
+			Expect(err).To(HaveOccurred())
+			Expect(collector).To(BeNil())
+		})
+
+		It("returns an error for an option of the QueryCollector", func() {
+			collector, err := NewPoolCollector(WithWatchdog(WatchdogConfig{Threshold: time.Second}))
+			Expect(err).To(MatchError("WithWatchdog does not apply to the PoolCollector"))
+			Expect(collector).To(BeNil())
+		})
+
+		It("uses the configured namespace, subsystem and label names", func() {
+			collector := newPoolCollector(
+				WithNamespace("app"),
+				WithSubsystem("db"),
+				WithLabelNames(map[string]string{"database": "db_name"}),
+				WithConstLabels(prometheus.Labels{"service": "orders"}),
+			)
+
+			ch := make(chan *prometheus.Desc, 20)
+			collector.Describe(ch)
+			close(ch)
+
+			desc := (<-ch).String()
+			Expect(desc).To(ContainSubstring(`fqName: "app_db_acquires_total"`))
+			Expect(desc).To(ContainSubstring(`{service="orders"}`))
+			Expect(desc).To(ContainSubstring(`variableLabels: {db_name,pool}`))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("Add", func() {
+		var (
+			primary   *pgxpool.Pool
+			replica   *pgxpool.Pool
+			collector *PoolCollector
+		)
+
+		BeforeEach(func() {
+			primary = newLazyPool("postgres://primary/orders")
+			replica = newLazyPool("postgres://replica/orders")
+			collector = newPoolCollector(WithPoolLabels("role"))
+		})
+
+		AfterEach(func() {
+			primary.Close()
+			replica.Close()
+		})
+
+		It("emits zero acquire counters for an unused pool", func() {
+			Expect(collector.Add(primary)).To(Succeed())
+			Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP pgx_pool_acquires_total Total number of successful connection acquires.
+# TYPE pgx_pool_acquires_total counter
+pgx_pool_acquires_total{database="orders",pool="orders",role=""} 0
+# HELP pgx_pool_acquire_duration_seconds_total Total time spent on successful connection acquires.
+# TYPE pgx_pool_acquire_duration_seconds_total counter
+pgx_pool_acquire_duration_seconds_total{database="orders",pool="orders",role=""} 0
+# HELP pgx_pool_empty_acquire_wait_seconds_total Total time spent waiting on an empty pool by successful connection acquires.
+# TYPE pgx_pool_empty_acquire_wait_seconds_total counter
+pgx_pool_empty_acquire_wait_seconds_total{database="orders",pool="orders",role=""} 0
+`), "pgx_pool_acquires_total", "pgx_pool_acquire_duration_seconds_total", "pgx_pool_empty_acquire_wait_seconds_total")).To(Succeed())
+		})
+
+		It("names the pool after its database", func() {
+			Expect(collector.Add(primary)).To(Succeed())
+			Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP pgx_pool_max_connections Maximum number of connections allowed in the pool.
+# TYPE pgx_pool_max_connections gauge
+pgx_pool_max_connections{database="orders",pool="orders",role=""} 4
+`), "pgx_pool_max_connections")).To(Succeed())
+		})
+
+		It("labels pools of the same database by name", func() {
+			Expect(collector.AddWithLabels(primary, "primary", prometheus.Labels{"role": "rw"})).To(Succeed())
+			Expect(collector.AddWithLabels(replica, "replica", prometheus.Labels{"role": "ro"})).To(Succeed())
+			Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP pgx_pool_max_connections Maximum number of connections allowed in the pool.
+# TYPE pgx_pool_max_connections gauge
+pgx_pool_max_connections{database="orders",pool="primary",role="rw"} 4
+pgx_pool_max_connections{database="orders",pool="replica",role="ro"} 4
+`), "pgx_pool_max_connections")).To(Succeed())
+		})
+
+		It("rejects a pool that is already added", func() {
+			Expect(collector.AddNamed(primary, "primary")).To(Succeed())
+			Expect(collector.AddNamed(primary, "other")).NotTo(Succeed())
+		})
+
+		It("rejects a pool with the same labels as an added pool", func() {
+			Expect(collector.Add(primary)).To(Succeed())
+			Expect(collector.Add(replica)).NotTo(Succeed())
+		})
+
+		It("rejects an undeclared label", func() {
+			Expect(collector.AddWithLabels(primary, "primary", prometheus.Labels{"shard": "1"})).NotTo(Succeed())
+		})
+
+		It("accepts a pool again after it was removed", func() {
+			Expect(collector.Add(primary)).To(Succeed())
+			collector.Remove(primary)
+			Expect(collector.Add(replica)).To(Succeed())
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TraceAcquireStart / TraceAcquireEnd", func() {
+		var (
+			pool      *pgxpool.Pool
+			collector *PoolCollector
+		)
+
+		BeforeEach(func() {
+			pool = newLazyPool("postgres://primary/orders")
+			collector = newPoolCollector()
+		})
+
+		AfterEach(func() {
+			pool.Close()
+		})
+
+		It("tracks acquires in flight", func() {
+			Expect(collector.AddNamed(pool, "primary")).To(Succeed())
+
+			ctx := collector.TraceAcquireStart(context.Background(), pool, pgxpool.TraceAcquireStartData{})
+			Expect(testutil.ToFloat64(collector.acquiresInFlight.WithLabelValues("orders", "primary"))).To(Equal(1.0))
+
+			collector.TraceAcquireEnd(ctx, pool, pgxpool.TraceAcquireEndData{})
+			Expect(testutil.ToFloat64(collector.acquiresInFlight.WithLabelValues("orders", "primary"))).To(Equal(0.0))
+		})
+
+		DescribeTable("labels the acquire duration with the outcome",
+			func(err error, outcome string) {
+				Expect(collector.AddNamed(pool, "primary")).To(Succeed())
+
+				ctx := collector.TraceAcquireStart(context.Background(), pool, pgxpool.TraceAcquireStartData{})
+				collector.TraceAcquireEnd(ctx, pool, pgxpool.TraceAcquireEndData{Err: err})
+
+				Expect(testutil.CollectAndCount(collector.acquireDuration)).To(Equal(1))
+				Expect(collector.acquireDuration.DeleteLabelValues("orders", "primary", outcome)).To(BeTrue())
+			},
+			Entry("success", nil, "ok"),
+			Entry("canceled", context.Canceled, "canceled"),
+			Entry("deadline exceeded", fmt.Errorf("acquire: %w", context.DeadlineExceeded), "canceled"),
+			Entry("error", errors.New("connection refused"), "error"),
+		)
+
+		It("ignores pools that are not added", func() {
+			ctx := collector.TraceAcquireStart(context.Background(), pool, pgxpool.TraceAcquireStartData{})
+			collector.TraceAcquireEnd(ctx, pool, pgxpool.TraceAcquireEndData{})
+
+			Expect(testutil.CollectAndCount(collector.acquireDuration)).To(Equal(0))
+			Expect(testutil.CollectAndCount(collector.acquiresInFlight)).To(Equal(0))
+		})
+
+		It("deletes the series of a removed pool", func() {
+			Expect(collector.AddNamed(pool, "primary")).To(Succeed())
+
+			ctx := collector.TraceAcquireStart(context.Background(), pool, pgxpool.TraceAcquireStartData{})
+			collector.TraceAcquireEnd(ctx, pool, pgxpool.TraceAcquireEndData{})
+			collector.Remove(pool)
+
+			Expect(testutil.CollectAndCount(collector.acquireDuration)).To(Equal(0))
+			Expect(testutil.CollectAndCount(collector.acquiresInFlight)).To(Equal(0))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TraceRelease", func() {
+		var (
+			pool      *pgxpool.Pool
+			conn      *pgx.Conn
+			collector *PoolCollector
+		)
+
+		BeforeEach(func() {
+			pool = newLazyPool("postgres://primary/orders")
+			conn = &pgx.Conn{}
+			collector = newPoolCollector()
+			Expect(collector.AddNamed(pool, "primary")).To(Succeed())
+		})
+
+		AfterEach(func() {
+			pool.Close()
+		})
+
+		acquire := func() {
+			ctx := collector.TraceAcquireStart(context.Background(), pool, pgxpool.TraceAcquireStartData{})
+			collector.TraceAcquireEnd(ctx, pool, pgxpool.TraceAcquireEndData{Conn: conn})
+		}
+
+		It("observes the hold time of a released connection", func() {
+			acquire()
+			collector.TraceRelease(pool, pgxpool.TraceReleaseData{Conn: conn})
+
+			Expect(testutil.CollectAndCount(collector.holdDuration)).To(Equal(1))
+		})
+
+		It("observes each acquire once", func() {
+			acquire()
+			collector.TraceRelease(pool, pgxpool.TraceReleaseData{Conn: conn})
+			collector.TraceRelease(pool, pgxpool.TraceReleaseData{Conn: conn})
+
+			Expect(sampleCount(collector.holdDuration.WithLabelValues("orders", "primary"))).To(Equal(uint64(1)))
+		})
+
+		It("ignores connections whose acquire was not traced", func() {
+			collector.TraceRelease(pool, pgxpool.TraceReleaseData{Conn: conn})
+
+			Expect(testutil.CollectAndCount(collector.holdDuration)).To(Equal(0))
+		})
+
+		It("forgets held connections of a removed pool", func() {
+			acquire()
+			collector.Remove(pool)
+			collector.TraceRelease(pool, pgxpool.TraceReleaseData{Conn: conn})
+
+			Expect(testutil.CollectAndCount(collector.holdDuration)).To(Equal(0))
 		})
 	})
 
@@ -56,10 +413,9 @@ var _ = Describe("PoolCollector", func() {
 				Skip("PGX_DATABASE_URL not set")
 			}
 
-			pool, _ = newPool(nil)
-
-			collector = NewPoolCollector()
-			collector.Add(pool)
+			collector = newPoolCollector()
+			pool, _ = newPool(collector)
+			Expect(collector.Add(pool)).To(Succeed())
 
 			reg = prometheus.NewRegistry()
 			Expect(reg.Register(collector)).To(Succeed())
@@ -71,10 +427,35 @@ var _ = Describe("PoolCollector", func() {
 			}
 		})
 
-		It("Collect emits one metric per descriptor (10 total)", func() {
+		It("Collect emits one metric per pool descriptor (13 total)", func() {
 			count, err := testutil.GatherAndCount(reg)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(count).To(Equal(10))
+			Expect(count).To(Equal(13))
+		})
+
+		It("counts acquires and their duration", func() {
+			conn, err := pool.Acquire(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			conn.Release()
+
+			count, err := testutil.GatherAndCount(reg,
+				"pgx_pool_acquires_total",
+				"pgx_pool_acquire_duration_seconds_total",
+				"pgx_pool_empty_acquire_wait_seconds_total",
+			)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(3))
+			Expect(pool.Stat().AcquireCount()).To(BeNumerically(">", 0))
+		})
+
+		It("observes pgx_pool_acquire_duration_seconds through the tracer", func() {
+			conn, err := pool.Acquire(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			conn.Release()
+
+			count, err := testutil.GatherAndCount(reg, "pgx_pool_acquire_duration_seconds", "pgx_pool_acquires_in_flight")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(2))
 		})
 
 		It("emits pgx_pool_max_connections with the database label", func() {
@@ -91,7 +472,7 @@ var _ = Describe("PoolCollector", func() {
 
 		It("Remove stops emitting metrics for the pool", func() {
 			collector.Remove(pool)
-			defer collector.Add(pool)
+			defer func() { Expect(collector.Add(pool)).To(Succeed()) }()
 
 			count, err := testutil.GatherAndCount(reg, "pgx_pool_max_connections")
 			Expect(err).NotTo(HaveOccurred())
@@ -104,28 +485,61 @@ var _ = Describe("QueryCollector", func() {
 	// -------------------------------------------------------------------------
 	Describe("NewQueryCollector", func() {
 		It("returns a non-nil collector", func() {
-			Expect(NewQueryCollector()).NotTo(BeNil())
+			Expect(newQueryCollector()).NotTo(BeNil())
 		})
 
-		It("Describe sends 3 descriptors", func() {
-			ch := make(chan *prometheus.Desc, 10)
-			NewQueryCollector().Describe(ch)
+		It("Describe sends 22 descriptors", func() {
+			ch := make(chan *prometheus.Desc, 30)
+			newQueryCollector().Describe(ch)
 			close(ch)
-			Expect(ch).To(HaveLen(3))
+			Expect(ch).To(HaveLen(22))
 		})
 
 		It("registers on a fresh registry without error", func() {
-			Expect(prometheus.NewRegistry().Register(NewQueryCollector())).To(Succeed())
+			Expect(prometheus.NewRegistry().Register(newQueryCollector())).To(Succeed())
+		})
+
+		It("returns an error for an invalid option", func() {
+			collector, err := NewQueryCollector(WithBuckets(1, 0.5))
+			Expect(err).To(HaveOccurred())
+			Expect(collector).To(BeNil())
+		})
+
+		It("returns an error when a const label clashes with a variable label", func() {
+			_, err := NewQueryCollector(WithConstLabels(prometheus.Labels{"database": "orders"}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("uses the configured options", func() {
+			collector := newQueryCollector(
+				WithNamespace("app"),
+				WithBuckets(0.0001, 0.001),
+				WithLabelNames(map[string]string{"db_operation": "operation"}),
+				WithConstLabels(prometheus.Labels{"service": "orders"}),
+			)
+
+			collector.duration.WithLabelValues("test", "GetUser").Observe(0.0005)
+
+			expected := `
+# HELP app_conn_request_duration_seconds Time taken to complete a database request.
+# TYPE app_conn_request_duration_seconds histogram
+app_conn_request_duration_seconds_bucket{database="test",operation="GetUser",service="orders",le="0.0001"} 0
+app_conn_request_duration_seconds_bucket{database="test",operation="GetUser",service="orders",le="0.001"} 1
+app_conn_request_duration_seconds_bucket{database="test",operation="GetUser",service="orders",le="+Inf"} 1
+app_conn_request_duration_seconds_sum{database="test",operation="GetUser",service="orders"} 0.0005
+app_conn_request_duration_seconds_count{database="test",operation="GetUser",service="orders"} 1
+`
+			Expect(testutil.CollectAndCompare(collector.duration, strings.NewReader(expected))).To(Succeed())
 		})
 	})
 
 	// -------------------------------------------------------------------------
 	Describe("name", func() {
-		q := NewQueryCollector()
+		q := newQueryCollector()
 
 		DescribeTable("extracts operation name from SQL",
 			func(sql, expected string) {
-				Expect(q.name(sql)).To(Equal(expected))
+				Expect(q.name(context.Background(), sql)).To(Equal(expected))
 			},
 			Entry("-- name: comment", "-- name: FindUser\nSELECT 1", "FindUser"),
 			Entry("plain SQL falls back to unknown", "SELECT * FROM users", "unknown"),
@@ -135,6 +549,569 @@ var _ = Describe("QueryCollector", func() {
 			Entry("underscore in identifier", "-- name: get_user\nSELECT 1", "get_user"),
 			Entry("digit in identifier", "-- name: query2\nSELECT 1", "query2"),
 		)
+
+		It("falls back to the operation in the context", func() {
+			ctx := WithOperation(context.Background(), "ListOrders")
+			Expect(q.name(ctx, "SELECT * FROM orders")).To(Equal("ListOrders"))
+		})
+
+		It("prefers the comment over the operation in the context", func() {
+			ctx := WithOperation(context.Background(), "ListOrders")
+			Expect(q.name(ctx, "-- name: FindOrder\nSELECT 1")).To(Equal("FindOrder"))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("statement labels", func() {
+		It("records the statement verb and table of a request", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithStatementLabels(), WithContextLabels("tenant"))
+
+			ctx := WithLabels(context.Background(), prometheus.Labels{"tenant": "acme"})
+			ctx = collector.TraceQueryStart(ctx, conn, pgx.TraceQueryStartData{SQL: "UPDATE users SET name = $1"})
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "unknown", "UPDATE", "users", "acme"))).To(Equal(1.0))
+		})
+
+		It("caches the labels derived from the SQL text", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithStatementLabels(), WithContextLabels("tenant"), WithSQLCommenterLabels("route"))
+
+			const sql = "UPDATE users SET name = $1 /*route='%2Fusers'*/"
+			for _, tenant := range []string{"acme", "globex"} {
+				ctx := WithLabels(context.Background(), prometheus.Labels{"tenant": tenant})
+				ctx = collector.TraceQueryStart(ctx, conn, pgx.TraceQueryStartData{SQL: sql})
+				collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+			}
+
+			values, ok := collector.statements.get(sql)
+			Expect(ok).To(BeTrue())
+			Expect(values).To(Equal([]string{"UPDATE", "users", "/users"}))
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "unknown", "UPDATE", "users", "acme", "/users"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "unknown", "UPDATE", "users", "globex", "/users"))).To(Equal(1.0))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("query kind label", func() {
+		DescribeTable("records the sqlc query annotation",
+			func(sql, kind string) {
+				conn := newFakeConn("orders")
+				collector := newQueryCollector(WithQueryKindLabel())
+
+				ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: sql})
+				collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+
+				Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "GetUser", kind))).To(Equal(1.0))
+				Expect(sampleCount(collector.rows.WithLabelValues("orders", "GetUser", kind, "SELECT"))).To(Equal(uint64(1)))
+			},
+			Entry("one", "-- name: GetUser :one\nSELECT 1", "one"),
+			Entry("exec", "-- name: GetUser :exec\nSELECT 1", "exec"),
+			Entry("no annotation", "-- name: GetUser\nSELECT 1", ""),
+		)
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("native histogram", func() {
+		It("records the request duration as a native histogram", func() {
+			collector := newQueryCollector(WithNativeHistogram(NativeHistogramConfig{BucketFactor: 1.05}))
+			observer := collector.duration.WithLabelValues("orders", "GetUser")
+			observer.Observe(0.0003)
+			observer.Observe(42)
+
+			h := histogram(observer)
+			Expect(h.GetSchema()).To(Equal(int32(4)))
+			Expect(h.GetPositiveSpan()).NotTo(BeEmpty())
+			Expect(h.GetBucket()).To(BeEmpty())
+			Expect(h.GetSampleCount()).To(Equal(uint64(2)))
+		})
+
+		It("keeps the classic buckets when asked to", func() {
+			collector := newQueryCollector(
+				WithBuckets(.01, .1, 1),
+				WithNativeHistogram(NativeHistogramConfig{Classic: true}),
+			)
+			observer := collector.duration.WithLabelValues("orders", "GetUser")
+			observer.Observe(0.05)
+
+			h := histogram(observer)
+			Expect(h.GetPositiveSpan()).NotTo(BeEmpty())
+			Expect(h.GetBucket()).To(HaveLen(3))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("summary", func() {
+		It("records the request duration as a summary", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithSummary(SummaryConfig{
+				Objectives: map[float64]float64{0.5: 0.05, 0.99: 0.001},
+				MaxAge:     time.Minute,
+			}))
+
+			ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+			metric := &dto.Metric{}
+			Expect(collector.duration.WithLabelValues("orders", "GetUser").(prometheus.Metric).Write(metric)).To(Succeed())
+			Expect(metric.GetSummary().GetSampleCount()).To(Equal(uint64(1)))
+			Expect(metric.GetSummary().GetQuantile()).To(HaveLen(2))
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "GetUser"))).To(Equal(1.0))
+		})
+
+		It("rejects a summary combined with a native histogram", func() {
+			_, err := NewQueryCollector(WithSummary(SummaryConfig{}), WithNativeHistogram(NativeHistogramConfig{}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("cardinality limits", func() {
+		It("records values beyond a label limit as the overflow value", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithLabelLimits(map[string]int{"db_operation": 1}))
+
+			for _, sql := range []string{"-- name: GetUser\nSELECT 1", "-- name: ListUsers\nSELECT 1", "-- name: GetUser\nSELECT 1"} {
+				ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: sql})
+				collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+			}
+
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "GetUser"))).To(Equal(2.0))
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "__overflow__"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.droppedTotal.WithLabelValues("db_operation"))).To(Equal(1.0))
+		})
+
+		It("limits the CopyFrom table and connect host labels", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithLabelLimits(map[string]int{"db_table": 1, "host": 1}))
+
+			for _, table := range []string{"users", "orders"} {
+				ctx := collector.TraceCopyFromStart(context.Background(), conn, pgx.TraceCopyFromStartData{TableName: pgx.Identifier{table}})
+				collector.TraceCopyFromEnd(ctx, conn, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag("COPY 1")})
+			}
+
+			for _, url := range []string{"postgres://db1/orders", "postgres://db2/orders"} {
+				config, err := pgx.ParseConfig(url)
+				Expect(err).NotTo(HaveOccurred())
+
+				ctx := collector.TraceConnectStart(context.Background(), pgx.TraceConnectStartData{ConnConfig: config})
+				collector.TraceConnectEnd(ctx, pgx.TraceConnectEndData{})
+			}
+
+			Expect(testutil.ToFloat64(collector.copyRequestTotal.WithLabelValues("orders", "users"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.copyRequestTotal.WithLabelValues("orders", "__overflow__"))).To(Equal(1.0))
+			Expect(sampleCount(collector.connectDuration.WithLabelValues("db1"))).To(Equal(uint64(1)))
+			Expect(sampleCount(collector.connectDuration.WithLabelValues("__overflow__"))).To(Equal(uint64(1)))
+			Expect(testutil.ToFloat64(collector.droppedTotal.WithLabelValues("db_table"))).To(Equal(2.0))
+			Expect(testutil.ToFloat64(collector.droppedTotal.WithLabelValues("host"))).To(Equal(1.0))
+		})
+
+		It("records combinations beyond the series limit as the overflow value", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithSeriesLimit(1))
+
+			for _, sql := range []string{"-- name: GetUser\nSELECT 1", "-- name: ListUsers\nSELECT 1"} {
+				ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: sql})
+				collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+			}
+
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("__overflow__", "__overflow__"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.droppedTotal.WithLabelValues("__series__"))).To(Equal(1.0))
+		})
+
+		It("exposes the dropped values counter only when a limit is set", func() {
+			ch := make(chan *prometheus.Desc, 30)
+			newQueryCollector(WithSeriesLimit(10)).Describe(ch)
+			close(ch)
+			Expect(ch).To(HaveLen(23))
+		})
+
+		It("lets collectors with different namespaces share a registry", func() {
+			registry := prometheus.NewPedanticRegistry()
+			Expect(registry.Register(newQueryCollector(WithNamespace("svc1"), WithSeriesLimit(10)))).To(Succeed())
+			Expect(registry.Register(newQueryCollector(WithNamespace("svc2"), WithSeriesLimit(10)))).To(Succeed())
+		})
+
+		It("rejects a const label that clashes with the dropped values counter", func() {
+			_, err := NewQueryCollector(WithConstLabels(prometheus.Labels{"label": "x"}), WithLabelLimit(10))
+			Expect(err).To(HaveOccurred())
+
+			_, err = NewQueryCollector(WithConstLabels(prometheus.Labels{"collector": "x"}), WithLabelLimit(10))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects a limit of an unknown label", func() {
+			_, err := NewQueryCollector(WithLabelLimits(map[string]int{"tenant": 10}))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("context labels", func() {
+		It("records the declared labels from the context", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithContextLabels("tenant", "endpoint"))
+
+			ctx := WithOperation(context.Background(), "ListOrders")
+			ctx = WithLabels(ctx, prometheus.Labels{"tenant": "acme"})
+			ctx = WithLabels(ctx, prometheus.Labels{"endpoint": "/orders", "ignored": "x"})
+
+			ctx = collector.TraceQueryStart(ctx, conn, pgx.TraceQueryStartData{SQL: "SELECT * FROM orders"})
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "ListOrders", "acme", "/orders"))).To(Equal(1.0))
+			Expect(sampleCount(collector.duration.WithLabelValues("orders", "ListOrders", "acme", "/orders"))).To(Equal(uint64(1)))
+		})
+
+		It("reads the labels of batch queries from the context of the batch", func() {
+			conn := newFakeConn("orders")
+			collector := newQueryCollector(WithContextLabels("tenant"))
+
+			batch := &pgx.Batch{}
+			batch.Queue("SELECT 1")
+
+			ctx := WithLabels(WithOperation(context.Background(), "Refresh"), prometheus.Labels{"tenant": "acme"})
+			ctx = collector.TraceBatchStart(ctx, conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{SQL: "SELECT 1"})
+			collector.TraceBatchEnd(ctx, conn, pgx.TraceBatchEndData{})
+
+			Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "Refresh", "acme"))).To(Equal(1.0))
+		})
+
+		It("rejects a context label that clashes with a built-in label", func() {
+			_, err := NewQueryCollector(WithContextLabels("database"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TraceConnectStart / TraceConnectEnd", func() {
+		It("records the duration and the error class of a failed connection", func() {
+			collector := newQueryCollector()
+
+			config, err := pgx.ParseConfig("postgres://127.0.0.1:1/orders?connect_timeout=5")
+			Expect(err).NotTo(HaveOccurred())
+			config.Tracer = collector
+
+			_, err = pgx.ConnectConfig(context.Background(), config)
+			Expect(err).To(HaveOccurred())
+
+			Expect(testutil.ToFloat64(collector.connectErrors.WithLabelValues("127.0.0.1", "network"))).To(Equal(1.0))
+			Expect(sampleCount(collector.connectDuration.WithLabelValues("127.0.0.1"))).To(Equal(uint64(1)))
+		})
+
+		It("does not count an error for a successful connection", func() {
+			collector := newQueryCollector()
+
+			config, err := pgx.ParseConfig("postgres://db1/orders")
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx := collector.TraceConnectStart(context.Background(), pgx.TraceConnectStartData{ConnConfig: config})
+			collector.TraceConnectEnd(ctx, pgx.TraceConnectEndData{})
+
+			Expect(testutil.CollectAndCount(collector.connectErrors)).To(Equal(0))
+			Expect(sampleCount(collector.connectDuration.WithLabelValues("db1"))).To(Equal(uint64(1)))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("requests in flight", func() {
+		var (
+			conn      *pgx.Conn
+			collector *QueryCollector
+		)
+
+		BeforeEach(func() {
+			conn = newFakeConn("orders")
+			collector = newQueryCollector()
+		})
+
+		It("tracks a query between TraceQueryStart and TraceQueryEnd", func() {
+			ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "GetUser"))).To(Equal(1.0))
+
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "GetUser"))).To(Equal(0.0))
+		})
+
+		It("reports the age of the oldest running query at scrape time", func() {
+			first := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			time.Sleep(20 * time.Millisecond)
+			second := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+
+			metrics := make(chan prometheus.Metric, 100)
+			collector.Collect(metrics)
+			close(metrics)
+
+			var ages []float64
+			for metric := range metrics {
+				if strings.Contains(metric.Desc().String(), "oldest_request_in_flight_seconds") {
+					m := &dto.Metric{}
+					Expect(metric.Write(m)).To(Succeed())
+					ages = append(ages, m.GetGauge().GetValue())
+				}
+			}
+			Expect(ages).To(HaveLen(1))
+			Expect(ages[0]).To(BeNumerically(">=", 0.02))
+
+			collector.TraceQueryEnd(first, conn, pgx.TraceQueryEndData{})
+			collector.TraceQueryEnd(second, conn, pgx.TraceQueryEndData{})
+			Expect(testutil.CollectAndCount(collector, "pgx_conn_oldest_request_in_flight_seconds")).To(Equal(0))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("watchdog", func() {
+		var (
+			conn   *pgx.Conn
+			server *fakeServer
+			output *gbytes.Buffer
+			logger *slog.Logger
+		)
+
+		BeforeEach(func() {
+			conn, server = newFakeServer("orders")
+			output = gbytes.NewBuffer()
+			logger = slog.New(slog.NewTextHandler(output, nil))
+		})
+
+		It("reports a request that exceeds its threshold once", func() {
+			collector := newQueryCollector(WithWatchdog(WatchdogConfig{
+				Threshold: time.Minute,
+				Thresholds: map[string]time.Duration{
+					"GetUser": time.Second,
+				},
+				Logger: logger,
+			}))
+			DeferCleanup(collector.Close)
+
+			ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: ListUsers\nSELECT 1"})
+
+			collector.scan(time.Now().Add(2 * time.Second))
+			collector.scan(time.Now().Add(3 * time.Second))
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+			Expect(testutil.ToFloat64(collector.stuckTotal.WithLabelValues("orders", "GetUser"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.stuckTotal.WithLabelValues("orders", "ListUsers"))).To(Equal(0.0))
+			Expect(output).To(gbytes.Say(`stuck database request.*db_operation=GetUser pid=42`))
+		})
+
+		It("cancels a stuck request when configured to", func() {
+			collector := newQueryCollector(WithWatchdog(WatchdogConfig{
+				Threshold: time.Second,
+				Cancel:    true,
+				Logger:    logger,
+			}))
+			DeferCleanup(collector.Close)
+
+			collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			collector.scan(time.Now().Add(2 * time.Second))
+
+			Expect(output).To(gbytes.Say("stuck database request"))
+			Expect(server.cancels).To(Receive(Equal(uint32(42))))
+			Expect(string(output.Contents())).NotTo(ContainSubstring("cancel stuck database request"))
+		})
+
+		It("does not cancel a request that has ended", func() {
+			collector := newQueryCollector(WithWatchdog(WatchdogConfig{
+				Threshold: time.Second,
+				Cancel:    true,
+				Logger:    logger,
+			}))
+			DeferCleanup(collector.Close)
+
+			ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			requests := collector.requests.all()
+			Expect(requests).To(HaveLen(1))
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+			collector.cancel(requests[0])
+			Consistently(server.cancels, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("scans in the background until closed", func() {
+			collector := newQueryCollector(WithWatchdog(WatchdogConfig{
+				Interval:  10 * time.Millisecond,
+				Threshold: time.Millisecond,
+				Logger:    logger,
+			}))
+
+			collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+			Eventually(func() float64 {
+				return testutil.ToFloat64(collector.stuckTotal.WithLabelValues("orders", "GetUser"))
+			}).Should(Equal(1.0))
+
+			collector.Close()
+			collector.Close()
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TraceBatchQuery", func() {
+		var (
+			conn      *pgx.Conn
+			collector *QueryCollector
+			batch     *pgx.Batch
+		)
+
+		BeforeEach(func() {
+			conn = newFakeConn("orders")
+			collector = newQueryCollector()
+
+			batch = &pgx.Batch{}
+			batch.Queue("-- name: Slow\nSELECT pg_sleep(0.05)")
+			batch.Queue("-- name: Fast\nSELECT 1")
+		})
+
+		It("observes the duration of each query individually", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			time.Sleep(50 * time.Millisecond)
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{SQL: batch.QueuedQueries[0].SQL})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{SQL: batch.QueuedQueries[1].SQL})
+			collector.TraceBatchEnd(ctx, conn, pgx.TraceBatchEndData{})
+
+			slow := collector.duration.WithLabelValues("orders", "Slow")
+			fast := collector.duration.WithLabelValues("orders", "Fast")
+			Expect(sampleCount(slow)).To(Equal(uint64(1)))
+			Expect(sampleCount(fast)).To(Equal(uint64(1)))
+			Expect(sampleSum(slow)).To(BeNumerically(">=", 0.05))
+			Expect(sampleSum(fast)).To(BeNumerically("<", 0.05))
+		})
+
+		It("labels a failed query with the error class", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[0].SQL,
+				Err: &pgconn.PgError{Code: "40001"},
+			})
+
+			Expect(testutil.ToFloat64(collector.errorsTotal.WithLabelValues("orders", "Slow", "40"))).To(Equal(1.0))
+		})
+
+		It("observes the rows of each query by command", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL:        batch.QueuedQueries[0].SQL,
+				CommandTag: pgconn.NewCommandTag("UPDATE 0"),
+			})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL:        batch.QueuedQueries[1].SQL,
+				CommandTag: pgconn.NewCommandTag("SELECT 5"),
+			})
+
+			Expect(testutil.CollectAndCompare(collector.rows, strings.NewReader(`
+# HELP pgx_conn_request_rows Number of rows affected or returned by a database request.
+# TYPE pgx_conn_request_rows histogram
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="0"} 0
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="1"} 0
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="10"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="100"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="1000"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="10000"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="100000"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="1e+06"} 1
+pgx_conn_request_rows_bucket{command="SELECT",database="orders",db_operation="Fast",le="+Inf"} 1
+pgx_conn_request_rows_sum{command="SELECT",database="orders",db_operation="Fast"} 5
+pgx_conn_request_rows_count{command="SELECT",database="orders",db_operation="Fast"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="0"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="1"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="10"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="100"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="1000"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="10000"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="100000"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="1e+06"} 1
+pgx_conn_request_rows_bucket{command="UPDATE",database="orders",db_operation="Slow",le="+Inf"} 1
+pgx_conn_request_rows_sum{command="UPDATE",database="orders",db_operation="Slow"} 0
+pgx_conn_request_rows_count{command="UPDATE",database="orders",db_operation="Slow"} 1
+`))).To(Succeed())
+		})
+
+		It("does not observe rows of a failed query", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[0].SQL,
+				Err: errors.New("boom"),
+			})
+
+			Expect(testutil.CollectAndCount(collector.rows)).To(Equal(0))
+		})
+
+		It("counts constraint violations by constraint, table and SQLSTATE", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[0].SQL,
+				Err: &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", TableName: "users"},
+			})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[1].SQL,
+				Err: &pgconn.PgError{Code: "40001"},
+			})
+
+			Expect(testutil.CollectAndCompare(collector.violationsTotal, strings.NewReader(`
+# HELP pgx_conn_constraint_violations_total Total number of integrity constraint violations by constraint and table.
+# TYPE pgx_conn_constraint_violations_total counter
+pgx_conn_constraint_violations_total{constraint="users_email_key",database="orders",db_table="users",sqlstate="23505"} 1
+`))).To(Succeed())
+		})
+
+		It("caps the number of tracked constraints", func() {
+			collector = newQueryCollector(WithConstraintLimit(1))
+
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[0].SQL,
+				Err: &pgconn.PgError{Code: "23505", ConstraintName: "users_email_key", TableName: "users"},
+			})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[1].SQL,
+				Err: &pgconn.PgError{Code: "23503", ConstraintName: "orders_user_id_fkey", TableName: "orders"},
+			})
+
+			Expect(testutil.CollectAndCompare(collector.violationsTotal, strings.NewReader(`
+# HELP pgx_conn_constraint_violations_total Total number of integrity constraint violations by constraint and table.
+# TYPE pgx_conn_constraint_violations_total counter
+pgx_conn_constraint_violations_total{constraint="__overflow__",database="orders",db_table="__overflow__",sqlstate="23503"} 1
+pgx_conn_constraint_violations_total{constraint="users_email_key",database="orders",db_table="users",sqlstate="23505"} 1
+`))).To(Succeed())
+		})
+
+		It("uses the configured error classifier", func() {
+			collector = newQueryCollector(WithErrorClassifier(func(error) string { return "custom" }))
+
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{
+				SQL: batch.QueuedQueries[0].SQL,
+				Err: errors.New("boom"),
+			})
+
+			Expect(testutil.ToFloat64(collector.errorsTotal.WithLabelValues("orders", "Slow", "custom"))).To(Equal(1.0))
+		})
+
+		It("tracks queued queries in flight until their result is read", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "Slow"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "Fast"))).To(Equal(1.0))
+
+			collector.TraceBatchQuery(ctx, conn, pgx.TraceBatchQueryData{SQL: batch.QueuedQueries[0].SQL})
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "Slow"))).To(Equal(0.0))
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "Fast"))).To(Equal(1.0))
+
+			collector.TraceBatchEnd(ctx, conn, pgx.TraceBatchEndData{})
+			Expect(testutil.ToFloat64(collector.inFlight.WithLabelValues("orders", "Fast"))).To(Equal(0.0))
+		})
+
+		It("observes the batch duration and size once per batch", func() {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: batch})
+			collector.TraceBatchEnd(ctx, conn, pgx.TraceBatchEndData{})
+
+			Expect(sampleCount(collector.batchDuration.WithLabelValues("orders"))).To(Equal(uint64(1)))
+			Expect(sampleCount(collector.batchSize.WithLabelValues("orders"))).To(Equal(uint64(1)))
+			Expect(sampleSum(collector.batchSize.WithLabelValues("orders"))).To(Equal(2.0))
+			Expect(testutil.CollectAndCount(collector.duration)).To(Equal(0))
+		})
 	})
 
 	// -------------------------------------------------------------------------
@@ -150,7 +1127,7 @@ var _ = Describe("QueryCollector", func() {
 				Skip("PGX_DATABASE_URL not set")
 			}
 
-			collector = NewQueryCollector()
+			collector = newQueryCollector()
 			pool, dbName = newPool(collector)
 		})
 
@@ -183,7 +1160,7 @@ var _ = Describe("QueryCollector", func() {
 		})
 
 		It("increments request_errors_total on a query error", func() {
-			labels := prometheus.Labels{"database": dbName, "db_operation": "unknown"}
+			labels := prometheus.Labels{"database": dbName, "db_operation": "unknown", "error_class": "22"}
 			before := testutil.ToFloat64(collector.errorsTotal.With(labels))
 
 			var val int
@@ -193,6 +1170,17 @@ var _ = Describe("QueryCollector", func() {
 			Expect(testutil.ToFloat64(collector.errorsTotal.With(labels))).To(Equal(before + 1))
 		})
 
+		It("observes request_rows with the command verb", func() {
+			labels := prometheus.Labels{"database": dbName, "db_operation": "unknown", "command": "SELECT"}
+			before := sampleCount(collector.rows.With(labels))
+
+			rows, err := pool.Query(context.Background(), "SELECT generate_series(1, 3)")
+			Expect(err).NotTo(HaveOccurred())
+			rows.Close()
+
+			Expect(sampleCount(collector.rows.With(labels))).To(Equal(before + 1))
+		})
+
 		It("observes request_duration_seconds after a query", func() {
 			rows, err := pool.Query(context.Background(), "SELECT 1")
 			Expect(err).NotTo(HaveOccurred())
@@ -215,7 +1203,7 @@ var _ = Describe("QueryCollector", func() {
 				Skip("PGX_DATABASE_URL not set")
 			}
 
-			collector = NewQueryCollector()
+			collector = newQueryCollector()
 			pool, dbName = newPool(collector)
 		})
 
@@ -262,7 +1250,7 @@ var _ = Describe("QueryCollector", func() {
 		})
 
 		It("increments request_errors_total for a failing batch query", func() {
-			labels := prometheus.Labels{"database": dbName, "db_operation": "unknown"}
+			labels := prometheus.Labels{"database": dbName, "db_operation": "unknown", "error_class": "22"}
 			before := testutil.ToFloat64(collector.errorsTotal.With(labels))
 
 			batch := &pgx.Batch{}
@@ -276,4 +1264,234 @@ var _ = Describe("QueryCollector", func() {
 			Expect(testutil.ToFloat64(collector.errorsTotal.With(labels))).To(Equal(before + 1))
 		})
 	})
+
+	// -------------------------------------------------------------------------
+	Describe("TraceCopyFromStart / TraceCopyFromEnd", Ordered, func() {
+		var (
+			pool      *pgxpool.Pool
+			conn      *pgxpool.Conn
+			collector *QueryCollector
+			dbName    string
+		)
+
+		BeforeAll(func() {
+			if os.Getenv("PGX_DATABASE_URL") == "" {
+				Skip("PGX_DATABASE_URL not set")
+			}
+
+			collector = newQueryCollector()
+			pool, dbName = newPool(collector)
+
+			var err error
+			conn, err = pool.Acquire(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = conn.Exec(context.Background(), "CREATE TEMPORARY TABLE copy_target (id int PRIMARY KEY)")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterAll(func() {
+			if conn != nil {
+				conn.Release()
+			}
+			if pool != nil {
+				pool.Close()
+			}
+		})
+
+		It("records requests, duration and rows copied", func() {
+			labels := prometheus.Labels{"database": dbName, "db_table": "copy_target"}
+			before := testutil.ToFloat64(collector.copyRowsTotal.With(labels))
+
+			rows := [][]any{{1}, {2}, {3}}
+			count, err := conn.CopyFrom(context.Background(), pgx.Identifier{"copy_target"}, []string{"id"}, pgx.CopyFromRows(rows))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(int64(3)))
+
+			Expect(testutil.ToFloat64(collector.copyRequestTotal.With(labels))).To(BeNumerically(">=", 1))
+			Expect(testutil.ToFloat64(collector.copyRowsTotal.With(labels))).To(Equal(before + 3))
+			Expect(sampleCount(collector.copyDuration.With(labels))).To(BeNumerically(">=", 1))
+			Expect(sampleCount(collector.copyRows.With(labels))).To(BeNumerically(">=", 1))
+		})
+
+		It("increments copy_from_errors_total on a failed copy", func() {
+			labels := prometheus.Labels{"database": dbName, "db_table": "copy_target"}
+			before := testutil.ToFloat64(collector.copyErrorsTotal.With(labels))
+
+			rows := [][]any{{1}}
+			_, err := conn.CopyFrom(context.Background(), pgx.Identifier{"copy_target"}, []string{"id"}, pgx.CopyFromRows(rows))
+			Expect(err).To(HaveOccurred())
+
+			Expect(testutil.ToFloat64(collector.copyErrorsTotal.With(labels))).To(Equal(before + 1))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TracePrepareEnd", func() {
+		var (
+			collector *QueryCollector
+			conn      *pgx.Conn
+		)
+
+		BeforeEach(func() {
+			collector = newQueryCollector()
+			conn = newFakeConn("app")
+		})
+
+		prepare := func(data pgx.TracePrepareEndData) {
+			ctx := collector.TracePrepareStart(context.Background(), conn, pgx.TracePrepareStartData{Name: "stmt", SQL: "SELECT 1"})
+			collector.TracePrepareEnd(ctx, conn, data)
+		}
+
+		It("counts and observes a prepare sent to the server", func() {
+			prepare(pgx.TracePrepareEndData{})
+
+			Expect(testutil.ToFloat64(collector.prepareTotal.WithLabelValues("app"))).To(Equal(1.0))
+			Expect(sampleCount(collector.prepareDuration.WithLabelValues("app"))).To(Equal(uint64(1)))
+			Expect(testutil.ToFloat64(collector.reprepareTotal.WithLabelValues("app"))).To(BeZero())
+		})
+
+		It("counts an already prepared statement only as a re-prepare", func() {
+			prepare(pgx.TracePrepareEndData{AlreadyPrepared: true})
+
+			Expect(testutil.ToFloat64(collector.reprepareTotal.WithLabelValues("app"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.prepareTotal.WithLabelValues("app"))).To(BeZero())
+			Expect(sampleCount(collector.prepareDuration.WithLabelValues("app"))).To(BeZero())
+		})
+
+		It("counts a failed prepare as a prepare and an error", func() {
+			prepare(pgx.TracePrepareEndData{Err: errors.New("syntax error")})
+
+			Expect(testutil.ToFloat64(collector.prepareTotal.WithLabelValues("app"))).To(Equal(1.0))
+			Expect(testutil.ToFloat64(collector.prepareErrors.WithLabelValues("app"))).To(Equal(1.0))
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("statement cache", func() {
+		var (
+			collector *QueryCollector
+			conn      *pgx.Conn
+		)
+
+		BeforeEach(func() {
+			collector = newQueryCollector()
+			conn = newFakeConn("app")
+		})
+
+		query := func(prepare bool, args ...any) {
+			ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "SELECT $1", Args: args})
+			if prepare {
+				ctx = collector.TracePrepareStart(ctx, conn, pgx.TracePrepareStartData{Name: "stmtcache_1", SQL: "SELECT $1"})
+				collector.TracePrepareEnd(ctx, conn, pgx.TracePrepareEndData{})
+			}
+			collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+		}
+
+		cache := func(result string) float64 {
+			return testutil.ToFloat64(collector.statementCache.WithLabelValues("app", result))
+		}
+
+		It("counts a query that prepares its statement as a miss", func() {
+			query(true, 1)
+
+			Expect(cache("miss")).To(Equal(1.0))
+			Expect(cache("hit")).To(BeZero())
+		})
+
+		It("counts a query that does not prepare its statement as a hit", func() {
+			query(false, 1)
+
+			Expect(cache("hit")).To(Equal(1.0))
+			Expect(cache("miss")).To(BeZero())
+		})
+
+		It("counts queries in the describe cache mode", func() {
+			query(false, pgx.QueryExecModeCacheDescribe, 1)
+
+			Expect(cache("hit")).To(Equal(1.0))
+		})
+
+		It("ignores queries that bypass the caches", func() {
+			query(false, pgx.QueryExecModeExec, 1)
+			query(false, pgx.QueryExecModeSimpleProtocol, 1)
+			query(true, pgx.QueryExecModeDescribeExec, 1)
+			query(false)
+
+			Expect(testutil.CollectAndCount(collector, "pgx_conn_statement_cache_total")).To(BeZero())
+		})
+	})
+
+	// -------------------------------------------------------------------------
+	Describe("TracePrepareStart / TracePrepareEnd", Ordered, func() {
+		var (
+			pool      *pgxpool.Pool
+			conn      *pgxpool.Conn
+			collector *QueryCollector
+			dbName    string
+		)
+
+		BeforeAll(func() {
+			if os.Getenv("PGX_DATABASE_URL") == "" {
+				Skip("PGX_DATABASE_URL not set")
+			}
+
+			collector = newQueryCollector()
+			pool, dbName = newPool(collector)
+
+			var err error
+			conn, err = pool.Acquire(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterAll(func() {
+			if conn != nil {
+				conn.Release()
+			}
+			if pool != nil {
+				pool.Close()
+			}
+		})
+
+		It("counts a prepare and then an explicit re-prepare", func() {
+			beforePrepares := testutil.ToFloat64(collector.prepareTotal.WithLabelValues(dbName))
+			beforeReprepares := testutil.ToFloat64(collector.reprepareTotal.WithLabelValues(dbName))
+			beforeDurations := sampleCount(collector.prepareDuration.WithLabelValues(dbName))
+
+			_, err := conn.Conn().Prepare(context.Background(), "prepare_cache", "SELECT 1")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = conn.Conn().Prepare(context.Background(), "prepare_cache", "SELECT 1")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(testutil.ToFloat64(collector.prepareTotal.WithLabelValues(dbName))).To(Equal(beforePrepares + 1))
+			Expect(testutil.ToFloat64(collector.reprepareTotal.WithLabelValues(dbName))).To(Equal(beforeReprepares + 1))
+			Expect(sampleCount(collector.prepareDuration.WithLabelValues(dbName))).To(Equal(beforeDurations + 1))
+		})
+
+		It("counts a statement cache miss and then a hit", func() {
+			miss := prometheus.Labels{"database": dbName, "result": "miss"}
+			hit := prometheus.Labels{"database": dbName, "result": "hit"}
+			beforeMiss := testutil.ToFloat64(collector.statementCache.With(miss))
+			beforeHit := testutil.ToFloat64(collector.statementCache.With(hit))
+
+			for range 2 {
+				rows, err := conn.Query(context.Background(), "SELECT $1::int /* statement cache */", 1)
+				Expect(err).NotTo(HaveOccurred())
+				rows.Close()
+				Expect(rows.Err()).NotTo(HaveOccurred())
+			}
+
+			Expect(testutil.ToFloat64(collector.statementCache.With(miss))).To(Equal(beforeMiss + 1))
+			Expect(testutil.ToFloat64(collector.statementCache.With(hit))).To(Equal(beforeHit + 1))
+		})
+
+		It("increments prepare_errors_total on an invalid statement", func() {
+			before := testutil.ToFloat64(collector.prepareErrors.WithLabelValues(dbName))
+
+			_, err := conn.Conn().Prepare(context.Background(), "prepare_error", "SELEC 1")
+			Expect(err).To(HaveOccurred())
+
+			Expect(testutil.ToFloat64(collector.prepareErrors.WithLabelValues(dbName))).To(Equal(before + 1))
+		})
+	})
 })
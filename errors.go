@@ -0,0 +1,86 @@
+package pgxprom
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrorClassifier maps a request error to the value of the error_class label.
+// It must return a small, fixed set of values.
+type ErrorClassifier func(err error) string
+
+// ErrorClass is the default ErrorClassifier. It returns the SQLSTATE class
+// (the first two characters of the code, for example "23" for integrity
+// constraint violations or "40" for transaction rollbacks) of a
+// *pgconn.PgError, "canceled" or "deadline_exceeded" for context errors,
+// "network" for network errors and "unknown" otherwise.
+func ErrorClass(err error) string {
+	var (
+		pgErr  *pgconn.PgError
+		netErr net.Error
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.As(err, &pgErr) && len(pgErr.Code) >= 2:
+		return pgErr.Code[:2]
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return "network"
+	default:
+		return "unknown"
+	}
+}
+
+// connectErrorClass returns the class of an error returned when a connection
+// could not be established: "auth", "tls", "network" or "other".
+func connectErrorClass(err error) string {
+	var (
+		pgErr          *pgconn.PgError
+		recordErr      tls.RecordHeaderError
+		alertErr       tls.AlertError
+		verifyErr      *tls.CertificateVerificationError
+		authorityErr   x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		certificateErr x509.CertificateInvalidError
+		netErr         net.Error
+	)
+
+	switch {
+	case errors.As(err, &pgErr):
+		// SQLSTATE class 28 is invalid_authorization_specification.
+		if strings.HasPrefix(pgErr.Code, "28") {
+			return "auth"
+		}
+		return "other"
+	case errors.As(err, &recordErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certificateErr):
+		return "tls"
+	// pgconn does not export the stage at which a connection attempt failed,
+	// so a refused or failed TLS handshake can only be detected by its message.
+	case strings.Contains(err.Error(), "tls error"),
+		strings.Contains(err.Error(), "server refused TLS connection"):
+		return "tls"
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return "network"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,49 @@
+package pgxprom
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ErrorClass", func() {
+	DescribeTable("classifies request errors",
+		func(err error, expected string) {
+			Expect(ErrorClass(err)).To(Equal(expected))
+		},
+		Entry("unique violation", &pgconn.PgError{Code: "23505"}, "23"),
+		Entry("deadlock", fmt.Errorf("exec: %w", &pgconn.PgError{Code: "40P01"}), "40"),
+		Entry("statement timeout", &pgconn.PgError{Code: "57014"}, "57"),
+		Entry("canceled", fmt.Errorf("query: %w", context.Canceled), "canceled"),
+		Entry("deadline exceeded", context.DeadlineExceeded, "deadline_exceeded"),
+		Entry("connection reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, "network"),
+		Entry("unexpected EOF", io.ErrUnexpectedEOF, "network"),
+		Entry("unknown error", errors.New("boom"), "unknown"),
+	)
+})
+
+var _ = Describe("connectErrorClass", func() {
+	DescribeTable("classifies connect errors",
+		func(err error, expected string) {
+			Expect(connectErrorClass(err)).To(Equal(expected))
+		},
+		Entry("invalid password", &pgconn.PgError{Code: "28P01"}, "auth"),
+		Entry("invalid authorization", fmt.Errorf("server error: %w", &pgconn.PgError{Code: "28000"}), "auth"),
+		Entry("unknown database", &pgconn.PgError{Code: "3D000"}, "other"),
+		Entry("unknown authority", fmt.Errorf("tls: %w", x509.UnknownAuthorityError{}), "tls"),
+		Entry("certificate verification", &tls.CertificateVerificationError{}, "tls"),
+		Entry("refused TLS", errors.New("server refused TLS connection"), "tls"),
+		Entry("dial error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network"),
+		Entry("deadline exceeded", context.DeadlineExceeded, "network"),
+		Entry("unexpected EOF", fmt.Errorf("receive message: %w", io.ErrUnexpectedEOF), "network"),
+		Entry("unknown error", errors.New("boom"), "other"),
+	)
+})
@@ -0,0 +1,94 @@
+package pgxprom
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExemplarExtractor returns the exemplar labels of a request, such as the ID
+// of the trace it belongs to, or nil if it has none. Exemplars whose labels
+// exceed prometheus.ExemplarMaxRunes or are not valid label names are
+// dropped.
+type ExemplarExtractor func(ctx context.Context) prometheus.Labels
+
+// OpenTelemetryExemplar is an ExemplarExtractor that returns the trace_id and
+// span_id of the sampled OpenTelemetry span in the context.
+func OpenTelemetryExemplar(ctx context.Context) prometheus.Labels {
+	span := trace.SpanContextFromContext(ctx)
+	if !span.IsValid() || !span.IsSampled() {
+		return nil
+	}
+
+	return prometheus.Labels{
+		"trace_id": span.TraceID().String(),
+		"span_id":  span.SpanID().String(),
+	}
+}
+
+// OpenTelemetryTraceparent returns the W3C traceparent of the OpenTelemetry
+// span in the context, or an empty string if there is none. It is meant as
+// the Traceparent of a SQLCommenter.
+func OpenTelemetryTraceparent(ctx context.Context) string {
+	span := trace.SpanContextFromContext(ctx)
+	if !span.IsValid() {
+		return ""
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", span.TraceID(), span.SpanID(), span.TraceFlags())
+}
+
+// exemplar returns the exemplar labels of the request, or nil if there is no
+// extractor or the labels are not valid.
+func (q *QueryCollector) exemplar(ctx context.Context) prometheus.Labels {
+	if q.extract == nil {
+		return nil
+	}
+
+	labels := q.extract(ctx)
+	if len(labels) == 0 {
+		return nil
+	}
+
+	runes := 0
+	for name, value := range labels {
+		if !labelPattern.MatchString(name) || !utf8.ValidString(value) {
+			return nil
+		}
+
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+
+	if runes > prometheus.ExemplarMaxRunes {
+		return nil
+	}
+
+	return labels
+}
+
+// observe records the value with the exemplar of the request, if any.
+func (q *QueryCollector) observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	if labels := q.exemplar(ctx); labels != nil {
+		if exemplar, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplar.ObserveWithExemplar(value, labels)
+			return
+		}
+	}
+
+	observer.Observe(value)
+}
+
+// inc increments the counter with the exemplar of the request, if any.
+func (q *QueryCollector) inc(ctx context.Context, counter prometheus.Counter) {
+	if labels := q.exemplar(ctx); labels != nil {
+		if exemplar, ok := counter.(prometheus.ExemplarAdder); ok {
+			exemplar.AddWithExemplar(1, labels)
+			return
+		}
+	}
+
+	counter.Inc()
+}
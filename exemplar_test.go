@@ -0,0 +1,87 @@
+package pgxprom
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("exemplars", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+			SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			TraceFlags: trace.FlagsSampled,
+		}))
+	})
+
+	It("OpenTelemetryExemplar returns the IDs of a sampled span", func() {
+		Expect(OpenTelemetryExemplar(ctx)).To(Equal(prometheus.Labels{
+			"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":  "00f067aa0ba902b7",
+		}))
+	})
+
+	It("OpenTelemetryExemplar returns nil without a sampled span", func() {
+		Expect(OpenTelemetryExemplar(context.Background())).To(BeNil())
+
+		unsampled := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx).WithTraceFlags(0))
+		Expect(OpenTelemetryExemplar(unsampled)).To(BeNil())
+	})
+
+	It("OpenTelemetryTraceparent returns the W3C traceparent of a span", func() {
+		Expect(OpenTelemetryTraceparent(ctx)).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+
+		unsampled := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx).WithTraceFlags(0))
+		Expect(OpenTelemetryTraceparent(unsampled)).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"))
+	})
+
+	It("OpenTelemetryTraceparent returns an empty string without a span", func() {
+		Expect(OpenTelemetryTraceparent(context.Background())).To(BeEmpty())
+	})
+
+	It("attaches the exemplar to the request duration and error counter", func() {
+		conn := newFakeConn("orders")
+		collector := newQueryCollector(WithExemplars(OpenTelemetryExemplar))
+
+		ctx = collector.TraceQueryStart(ctx, conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{Err: errors.New("boom")})
+
+		var exemplars []*dto.Exemplar
+		for _, bucket := range histogram(collector.duration.WithLabelValues("orders", "GetUser")).GetBucket() {
+			if bucket.GetExemplar() != nil {
+				exemplars = append(exemplars, bucket.GetExemplar())
+			}
+		}
+		Expect(exemplars).To(HaveLen(1))
+		Expect(exemplars[0].GetLabel()).To(ContainElement(HaveField("GetValue()", "4bf92f3577b34da6a3ce929d0e0e4736")))
+
+		metric := &dto.Metric{}
+		Expect(collector.errorsTotal.WithLabelValues("orders", "GetUser", "unknown").Write(metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(1.0))
+		Expect(metric.GetCounter().GetExemplar()).NotTo(BeNil())
+	})
+
+	It("drops exemplars that exceed the size limit", func() {
+		collector := newQueryCollector(WithExemplars(func(context.Context) prometheus.Labels {
+			return prometheus.Labels{"trace_id": strings.Repeat("x", 200)}
+		}))
+
+		counter := collector.errorsTotal.WithLabelValues("orders", "GetUser", "unknown")
+		collector.inc(context.Background(), counter)
+
+		metric := &dto.Metric{}
+		Expect(counter.Write(metric)).To(Succeed())
+		Expect(metric.GetCounter().GetValue()).To(Equal(1.0))
+		Expect(metric.GetCounter().GetExemplar()).To(BeNil())
+	})
+})
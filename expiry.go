@@ -0,0 +1,184 @@
+package pgxprom
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// series tracks when each label combination of the request metrics was last
+// used.
+type series struct {
+	mu      sync.Mutex
+	entries map[string]*seriesEntry
+}
+
+// seriesEntry is a label combination and the time it was last used.
+type seriesEntry struct {
+	labels []string
+	seenAt time.Time
+}
+
+// newSeries returns an empty set of label combinations.
+func newSeries() *series {
+	return &series{
+		entries: make(map[string]*seriesEntry),
+	}
+}
+
+// touch records that the label combination was used at now.
+func (s *series) touch(labels []string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := seriesKey(labels)
+	if entry, ok := s.entries[key]; ok {
+		entry.seenAt = now
+		return
+	}
+
+	s.entries[key] = &seriesEntry{labels: labels, seenAt: now}
+}
+
+// expire removes and returns the label combinations last used before the
+// given time, except for those in keep.
+func (s *series) expire(before time.Time, keep map[string]bool) [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired [][]string
+	for key, entry := range s.entries {
+		if entry.seenAt.Before(before) && !keep[key] {
+			expired = append(expired, entry.labels)
+			delete(s.entries, key)
+		}
+	}
+
+	return expired
+}
+
+// all returns the label combinations that have not expired.
+func (s *series) all() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels := make([][]string, 0, len(s.entries))
+	for _, entry := range s.entries {
+		labels = append(labels, entry.labels)
+	}
+
+	return labels
+}
+
+// seriesKey returns a map key of the label values.
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+// minJanitorInterval is the shortest interval at which the janitor checks for
+// expired series, however short the TTL.
+const minJanitorInterval = time.Millisecond
+
+// janitor deletes expired series until the collector is closed.
+func (q *QueryCollector) janitor() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(max(q.ttl/2, minJanitorInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case now := <-ticker.C:
+			q.expire(now)
+		}
+	}
+}
+
+// expire deletes the series of the request metrics whose label combination
+// has not been used for the TTL, and the series of the batch, CopyFrom,
+// prepare and constraint violation metrics of databases that have not been
+// used for the TTL. Combinations with requests in flight, and their
+// databases, are kept. The label values that are no longer used by any series
+// are released from their label limits.
+func (q *QueryCollector) expire(now time.Time) {
+	before := now.Add(-q.ttl)
+
+	running := make(map[string]bool)
+	for _, r := range q.requests.all() {
+		running[seriesKey(r.labels)] = true
+	}
+
+	vectors := []deletePartialMatcher{
+		q.requestTotal,
+		q.errorsTotal,
+		q.duration,
+		q.rows,
+		q.inFlight,
+		q.stuckTotal,
+	}
+
+	for _, values := range q.series.expire(before, running) {
+		labels := make(prometheus.Labels, len(values))
+		for index, name := range q.labelNames {
+			labels[name] = values[index]
+		}
+
+		for _, vector := range vectors {
+			vector.DeletePartialMatch(labels)
+		}
+
+		q.guard.forget(values)
+	}
+
+	// running combinations are never expired, so the remaining ones are all
+	// those in use
+	used := make([]map[string]bool, len(q.labelNames))
+	for index := range used {
+		used[index] = make(map[string]bool)
+	}
+
+	for _, values := range q.series.all() {
+		for index, value := range values {
+			used[index][value] = true
+		}
+	}
+
+	databaseVectors := []deletePartialMatcher{
+		q.batchDuration,
+		q.batchSize,
+		q.copyRequestTotal,
+		q.copyErrorsTotal,
+		q.copyDuration,
+		q.copyRows,
+		q.copyRowsTotal,
+		q.prepareTotal,
+		q.prepareErrors,
+		q.prepareDuration,
+		q.reprepareTotal,
+		q.statementCache,
+		q.violationsTotal,
+	}
+
+	// the keys of single label values are the values themselves
+	for _, values := range q.databases.expire(before, used[0]) {
+		for _, vector := range databaseVectors {
+			vector.DeletePartialMatch(prometheus.Labels{q.labelNames[0]: values[0]})
+		}
+	}
+
+	for _, values := range q.databases.all() {
+		used[0][values[0]] = true
+	}
+
+	q.guard.retain(used)
+}
+
+// deletePartialMatcher is a metric vector whose series can be deleted by a
+// subset of their labels.
+type deletePartialMatcher interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
@@ -0,0 +1,126 @@
+package pgxprom
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("series expiry", func() {
+	It("returns the combinations last used before the given time", func() {
+		now := time.Now()
+
+		s := newSeries()
+		s.touch([]string{"orders", "GetUser"}, now.Add(-time.Hour))
+		s.touch([]string{"orders", "ListUsers"}, now.Add(-time.Hour))
+		s.touch([]string{"orders", "ListUsers"}, now)
+		s.touch([]string{"orders", "Report"}, now.Add(-time.Hour))
+
+		keep := map[string]bool{seriesKey([]string{"orders", "Report"}): true}
+		Expect(s.expire(now.Add(-time.Minute), keep)).To(Equal([][]string{{"orders", "GetUser"}}))
+		Expect(s.expire(now.Add(-time.Minute), keep)).To(BeEmpty())
+	})
+
+	It("deletes the series of idle label combinations", func() {
+		conn := newFakeConn("orders")
+		collector := newQueryCollector(WithSeriesTTL(time.Minute), WithSeriesLimit(1))
+		DeferCleanup(collector.Close)
+
+		ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+		running := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+
+		collector.expire(time.Now().Add(2 * time.Minute))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_requests_total")).To(Equal(1))
+
+		collector.TraceQueryEnd(running, conn, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+		collector.expire(time.Now().Add(2 * time.Minute))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_requests_total")).To(Equal(0))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_request_rows")).To(Equal(0))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_request_duration_seconds")).To(Equal(0))
+
+		// the expired combination no longer counts towards the series limit
+		ctx = collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: ListUsers\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+		Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "ListUsers"))).To(Equal(1.0))
+	})
+
+	It("deletes the series of idle databases", func() {
+		orders := newFakeConn("orders")
+		tenant := newFakeConn("tenant")
+		collector := newQueryCollector(WithSeriesTTL(time.Minute))
+		DeferCleanup(collector.Close)
+
+		for _, conn := range []*pgx.Conn{orders, tenant} {
+			ctx := collector.TraceBatchStart(context.Background(), conn, pgx.TraceBatchStartData{Batch: &pgx.Batch{}})
+			collector.TraceBatchEnd(ctx, conn, pgx.TraceBatchEndData{})
+			ctx = collector.TraceCopyFromStart(context.Background(), conn, pgx.TraceCopyFromStartData{TableName: pgx.Identifier{"users"}})
+			collector.TraceCopyFromEnd(ctx, conn, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag("COPY 1")})
+			ctx = collector.TracePrepareStart(context.Background(), conn, pgx.TracePrepareStartData{SQL: "SELECT 1"})
+			collector.TracePrepareEnd(ctx, conn, pgx.TracePrepareEndData{})
+		}
+
+		// a database with a request in flight is kept
+		running := collector.TraceQueryStart(context.Background(), orders, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+
+		collector.expire(time.Now().Add(2 * time.Minute))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_batch_duration_seconds")).To(Equal(1))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_copy_from_rows_total")).To(Equal(1))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_prepares_total")).To(Equal(1))
+		Expect(testutil.ToFloat64(collector.prepareTotal.WithLabelValues("orders"))).To(Equal(1.0))
+
+		collector.TraceQueryEnd(running, orders, pgx.TraceQueryEndData{})
+		collector.expire(time.Now().Add(2 * time.Minute))
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_batch_duration_seconds")).To(BeZero())
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_batch_size")).To(BeZero())
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_copy_from_rows_total")).To(BeZero())
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_prepares_total")).To(BeZero())
+		Expect(testutil.CollectAndCount(collector, "pgx_conn_prepare_duration_seconds")).To(BeZero())
+	})
+
+	It("releases label values that are no longer used from their limits", func() {
+		conn := newFakeConn("orders")
+		collector := newQueryCollector(WithSeriesTTL(time.Minute), WithLabelLimits(map[string]int{"db_operation": 1}))
+		DeferCleanup(collector.Close)
+
+		ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+		collector.expire(time.Now().Add(2 * time.Minute))
+
+		ctx = collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: ListUsers\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+		Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "ListUsers"))).To(Equal(1.0))
+
+		// a value in use keeps its place
+		collector.expire(time.Now())
+
+		ctx = collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "-- name: GetUser\nSELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+		Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", overflowValue))).To(Equal(1.0))
+	})
+
+	It("expires series in the background until closed", func() {
+		conn := newFakeConn("orders")
+		collector := newQueryCollector(WithSeriesTTL(20 * time.Millisecond))
+
+		ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+		Eventually(func() int {
+			return testutil.CollectAndCount(collector, "pgx_conn_requests_total")
+		}).Should(Equal(0))
+
+		collector.Close()
+	})
+
+	It("runs the janitor for a TTL shorter than its minimum interval", func() {
+		collector := newQueryCollector(WithSeriesTTL(1))
+		collector.Close()
+	})
+})
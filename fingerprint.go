@@ -0,0 +1,117 @@
+package pgxprom
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// fingerprintOverflow is the name of requests whose fingerprint exceeds the
+// limit of a FingerprintNamer.
+const fingerprintOverflow = "other"
+
+// FingerprintNamer is an OperationNamer that names a request by a short hash
+// of its normalized SQL. Literals and parameters are replaced by placeholders,
+// lists of values are collapsed and keywords are lowercased, so requests that
+// differ only in their values share a name. It is meant as the last namer of
+// a ChainNamer, for requests that carry no name.
+type FingerprintNamer struct {
+	names   *lru[string]
+	limiter *limiter
+}
+
+// NewFingerprintNamer creates a FingerprintNamer that caches the names of up to
+// size SQL texts and returns at most limit distinct fingerprints. Requests
+// with further fingerprints are named "other".
+func NewFingerprintNamer(size, limit int) (*FingerprintNamer, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("fingerprint cache size must be positive: %d", size)
+	}
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("fingerprint limit must be positive: %d", limit)
+	}
+
+	return &FingerprintNamer{
+		names:   newLRU[string](size),
+		limiter: newLimiter(limit),
+	}, nil
+}
+
+// Name implements OperationNamer.
+func (f *FingerprintNamer) Name(_ context.Context, sql string) string {
+	if name, ok := f.names.get(sql); ok {
+		return name
+	}
+
+	name := fingerprint(sql)
+	if !f.limiter.allow(name) {
+		name = fingerprintOverflow
+	}
+
+	return f.names.add(sql, name)
+}
+
+// fingerprint returns the hash of the normalized SQL as 16 hex digits.
+func fingerprint(sql string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(normalize(sql)))
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// normalize returns the SQL with comments and whitespace removed, literals and
+// parameters replaced by "?", unquoted words lowercased and lists of values
+// such as IN (1, 2, 3) or VALUES (1, 2), (3, 4) collapsed to a single (?).
+func normalize(sql string) string {
+	var text []string
+
+	tokens := lex(sql)
+	for i := 0; i < len(tokens); i++ {
+		// a parenthesized list of placeholders
+		if end, ok := placeholderList(tokens, i); ok {
+			// a list that follows another list, as in VALUES
+			if n := len(text); n >= 4 && text[n-1] == "," && text[n-2] == ")" && text[n-3] == "?" && text[n-4] == "(" {
+				text = text[:n-1]
+			} else {
+				text = append(text, "(", "?", ")")
+			}
+
+			i = end
+			continue
+		}
+
+		text = append(text, tokens[i].text)
+	}
+
+	return strings.Join(text, " ")
+}
+
+// placeholderList reports whether the tokens at i are a parenthesized,
+// comma-separated list of literals and parameters, and returns the index of
+// the closing parenthesis.
+func placeholderList(tokens []token, i int) (int, bool) {
+	if tokens[i].text != "(" {
+		return 0, false
+	}
+
+	for j := i + 1; j < len(tokens); j += 2 {
+		if kind := tokens[j].kind; kind != tokenLiteral && kind != tokenParam {
+			return 0, false
+		}
+
+		if j+1 >= len(tokens) {
+			return 0, false
+		}
+
+		switch tokens[j+1].text {
+		case ")":
+			return j + 1, true
+		case ",":
+		default:
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package pgxprom
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FingerprintNamer", func() {
+	DescribeTable("normalize",
+		func(sql, expected string) {
+			Expect(normalize(sql)).To(Equal(expected))
+		},
+		Entry("keywords and whitespace", "SELECT *\n  FROM Users\tWHERE id = 1", "select * from users where id = ?"),
+		Entry("string literals", "SELECT 1 WHERE name = 'O''Brien' AND note = E'it\\'s'", "select ? where name = ? and note = ?"),
+		Entry("dollar-quoted strings", "SELECT $fn$body; 'x'$fn$, $$y$$", "select ? , ?"),
+		Entry("parameters", "UPDATE users SET name = $1 WHERE id = $2", "update users set name = ? where id = ?"),
+		Entry("numbers", "SELECT 1.5, .5, 1e-3, 10", "select ? , ? , ? , ?"),
+		Entry("quoted identifiers", `SELECT "Name" FROM "Users"`, `select "Name" from "Users"`),
+		Entry("comments", "-- name: GetUser\nSELECT /* hint */ 1", "select ?"),
+		Entry("IN lists", "SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in ( ? )"),
+		Entry("VALUES lists", "INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4), ($5, $6)", "insert into t ( a , b ) values ( ? )"),
+		Entry("operators", "SELECT a::text FROM t WHERE b >= 2", "select a :: text from t where b >= ?"),
+		Entry("signed numbers", "SELECT -1 FROM t WHERE x = -5 AND y=+2.5 AND z > - 3", "select ? from t where x = ? and y = ? and z > ?"),
+		Entry("signed numbers in IN lists", "SELECT * FROM t WHERE id IN (-1, 2, +3)", "select * from t where id in ( ? )"),
+		Entry("binary minus", "SELECT a - 1, (b) -2, c[1]-3 FROM t", "select a - ? , ( b ) - ? , c [ ? ] - ? from t"),
+		Entry("unterminated string", "SELECT 'abc", "select ?"),
+	)
+
+	It("gives queries that differ only in values the same name", func() {
+		namer, err := NewFingerprintNamer(10, 10)
+		Expect(err).NotTo(HaveOccurred())
+
+		name := namer.Name(context.Background(), "SELECT * FROM t WHERE id IN (1, 2)")
+		Expect(name).To(HaveLen(16))
+		Expect(namer.Name(context.Background(), "select * from t\nwhere id in ($1, $2, $3)")).To(Equal(name))
+		Expect(namer.Name(context.Background(), "SELECT * FROM u WHERE id IN (1, 2)")).NotTo(Equal(name))
+		Expect(namer.Name(context.Background(), "SELECT * FROM t WHERE id IN (-1, 2)")).To(Equal(name))
+		Expect(namer.Name(context.Background(), "SELECT * FROM t WHERE x = -5")).To(Equal(namer.Name(context.Background(), "SELECT * FROM t WHERE x = 5")))
+	})
+
+	It("names fingerprints beyond the limit other", func() {
+		namer, err := NewFingerprintNamer(10, 1)
+		Expect(err).NotTo(HaveOccurred())
+
+		name := namer.Name(context.Background(), "SELECT * FROM t")
+		Expect(namer.Name(context.Background(), "SELECT * FROM u")).To(Equal("other"))
+		Expect(namer.Name(context.Background(), "select * from t")).To(Equal(name))
+	})
+
+	It("evicts the least recently used SQL text", func() {
+		namer, err := NewFingerprintNamer(2, 10)
+		Expect(err).NotTo(HaveOccurred())
+
+		namer.Name(context.Background(), "SELECT 1")
+		namer.Name(context.Background(), "SELECT 2")
+		namer.Name(context.Background(), "SELECT 1")
+		namer.Name(context.Background(), "SELECT 3")
+
+		Expect(namer.names.entries).To(HaveKey("SELECT 1"))
+		Expect(namer.names.entries).To(HaveKey("SELECT 3"))
+		Expect(namer.names.entries).NotTo(HaveKey("SELECT 2"))
+	})
+
+	It("rejects invalid sizes", func() {
+		_, err := NewFingerprintNamer(0, 10)
+		Expect(err).To(HaveOccurred())
+
+		_, err = NewFingerprintNamer(10, 0)
+		Expect(err).To(HaveOccurred())
+	})
+})
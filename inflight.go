@@ -0,0 +1,95 @@
+package pgxprom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// request is a database request that has started and not yet ended.
+type request struct {
+	labels    []string
+	startedAt time.Time
+	sql       string
+	conn      *pgx.Conn
+	// stuck is set by the watchdog once it has reported the request.
+	stuck bool
+}
+
+// inflight tracks the requests that are currently running.
+type inflight struct {
+	mu       sync.Mutex
+	requests map[*request]struct{}
+}
+
+// newInflight returns an empty set of running requests.
+func newInflight() *inflight {
+	return &inflight{
+		requests: make(map[*request]struct{}),
+	}
+}
+
+// add starts tracking the request.
+func (f *inflight) add(r *request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests[r] = struct{}{}
+}
+
+// remove stops tracking the request. It reports whether the request was
+// tracked.
+func (f *inflight) remove(r *request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.requests[r]; !ok {
+		return false
+	}
+
+	delete(f.requests, r)
+	return true
+}
+
+// has reports whether the request is tracked.
+func (f *inflight) has(r *request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.requests[r]
+	return ok
+}
+
+// all returns the running requests.
+func (f *inflight) all() []*request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	requests := make([]*request, 0, len(f.requests))
+	for r := range f.requests {
+		requests = append(requests, r)
+	}
+
+	return requests
+}
+
+// oldest returns the oldest running request of each label combination.
+func (f *inflight) oldest() []*request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := make(map[string]*request)
+	for r := range f.requests {
+		key := seriesKey(r.labels)
+		if elem, ok := index[key]; !ok || r.startedAt.Before(elem.startedAt) {
+			index[key] = r
+		}
+	}
+
+	requests := make([]*request, 0, len(index))
+	for _, r := range index {
+		requests = append(requests, r)
+	}
+
+	return requests
+}
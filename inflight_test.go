@@ -0,0 +1,33 @@
+package pgxprom
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("inflight", func() {
+	It("returns the oldest request of each label combination", func() {
+		now := time.Now()
+		older := &request{labels: []string{"orders", "GetUser"}, startedAt: now.Add(-time.Second)}
+		newer := &request{labels: []string{"orders", "GetUser"}, startedAt: now}
+		other := &request{labels: []string{"orders", "ListUsers"}, startedAt: now}
+
+		f := newInflight()
+		f.add(newer)
+		f.add(older)
+		f.add(other)
+		Expect(f.oldest()).To(ConsistOf(older, other))
+	})
+
+	It("reports whether a removed request was tracked", func() {
+		r := &request{labels: []string{"orders", "GetUser"}}
+
+		f := newInflight()
+		f.add(r)
+		Expect(f.remove(r)).To(BeTrue())
+		Expect(f.remove(r)).To(BeFalse())
+		Expect(f.oldest()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,212 @@
+package pgxprom
+
+import "strings"
+
+// tokenKind is the kind of a SQL token.
+type tokenKind int
+
+const (
+	// tokenWord is an unquoted keyword or identifier, lowercased.
+	tokenWord tokenKind = iota
+	// tokenQuoted is a double-quoted identifier, including the quotes.
+	tokenQuoted
+	// tokenLiteral is a string, numeric or bit string constant.
+	tokenLiteral
+	// tokenParam is a positional parameter such as $1.
+	tokenParam
+	// tokenSymbol is an operator or punctuation.
+	tokenSymbol
+)
+
+// token is a lexical token of a SQL statement.
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex splits the SQL into tokens. Whitespace and comments are dropped. It is
+// not a full PostgreSQL lexer, but it is good enough to recognize the shape of
+// a statement and never fails on malformed input.
+func lex(sql string) []token {
+	var tokens []token
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+
+		switch {
+		case isSpace(c):
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(sql)
+			}
+		case c == '\'':
+			i = skipQuoted(sql, i+1, '\'', false)
+			tokens = append(tokens, token{kind: tokenLiteral, text: "?"})
+		case (c == 'e' || c == 'E') && i+1 < len(sql) && sql[i+1] == '\'':
+			i = skipQuoted(sql, i+2, '\'', true)
+			tokens = append(tokens, token{kind: tokenLiteral, text: "?"})
+		case strings.ContainsRune("bBxXnN", rune(c)) && i+1 < len(sql) && sql[i+1] == '\'':
+			i = skipQuoted(sql, i+2, '\'', false)
+			tokens = append(tokens, token{kind: tokenLiteral, text: "?"})
+		case c == '"':
+			end := skipQuoted(sql, i+1, '"', false)
+			tokens = append(tokens, token{kind: tokenQuoted, text: sql[i:end]})
+			i = end
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			i++
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenParam, text: "?"})
+		case c == '$':
+			if end, ok := skipDollarQuoted(sql, i); ok {
+				i = end
+				tokens = append(tokens, token{kind: tokenLiteral, text: "?"})
+			} else {
+				i++
+				tokens = append(tokens, token{kind: tokenSymbol, text: "$"})
+			}
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i = skipNumber(sql, i)
+			// a unary sign is part of the constant, so that -5 and 5 match
+			if n := len(tokens); n > 0 && (tokens[n-1].text == "-" || tokens[n-1].text == "+") && (n == 1 || precedesOperand(tokens[n-2])) {
+				tokens = tokens[:n-1]
+			}
+			tokens = append(tokens, token{kind: tokenLiteral, text: "?"})
+		case isWordStart(c):
+			start := i
+			for i < len(sql) && isWord(sql[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: strings.ToLower(sql[start:i])})
+		case isOperator(c):
+			start := i
+			for i < len(sql) && isOperator(sql[i]) && !strings.HasPrefix(sql[i:], "--") && !strings.HasPrefix(sql[i:], "/*") {
+				i++
+			}
+			// as in PostgreSQL, an operator ends in + or - only if it
+			// contains one of ~!@#%^&|`?, so =-5 is = followed by -5
+			for i-start > 1 && (sql[i-1] == '+' || sql[i-1] == '-') && !strings.ContainsAny(sql[start:i], "~!@#%^&|`?") {
+				i--
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: sql[start:i]})
+		default:
+			i++
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(c)})
+		}
+	}
+
+	return tokens
+}
+
+// operandKeywords are the keywords that are followed by an operand rather
+// than an operator.
+var operandKeywords = map[string]bool{
+	"and": true, "between": true, "by": true, "case": true, "else": true,
+	"ilike": true, "in": true, "is": true, "like": true, "limit": true,
+	"not": true, "offset": true, "or": true, "return": true, "returning": true,
+	"select": true, "set": true, "then": true, "values": true, "when": true,
+	"where": true,
+}
+
+// precedesOperand reports whether the token is followed by an operand, so
+// that a + or - after it is a unary sign rather than an operator.
+func precedesOperand(t token) bool {
+	switch t.kind {
+	case tokenWord:
+		return operandKeywords[t.text]
+	case tokenSymbol:
+		return t.text == "(" || t.text == "," || t.text == "[" || isOperator(t.text[0])
+	default:
+		return false
+	}
+}
+
+// skipQuoted returns the index after the closing quote of a quoted string that
+// starts at i. A doubled quote is an escaped quote, as is a quote preceded by a
+// backslash when backslash is set.
+func skipQuoted(sql string, i int, quote byte, backslash bool) int {
+	for i < len(sql) {
+		switch {
+		case backslash && sql[i] == '\\':
+			i += 2
+		case sql[i] == quote && i+1 < len(sql) && sql[i+1] == quote:
+			i += 2
+		case sql[i] == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+
+	return len(sql)
+}
+
+// skipDollarQuoted returns the index after a dollar-quoted string such as
+// $tag$...$tag$ that starts at i. It reports false when there is no valid
+// opening tag at i.
+func skipDollarQuoted(sql string, i int) (int, bool) {
+	end := i + 1
+	for end < len(sql) && sql[end] != '$' {
+		if !isWord(sql[end]) {
+			return 0, false
+		}
+		end++
+	}
+
+	if end >= len(sql) {
+		return 0, false
+	}
+
+	tag := sql[i : end+1]
+	if index := strings.Index(sql[end+1:], tag); index >= 0 {
+		return end + 1 + index + len(tag), true
+	}
+
+	return len(sql), true
+}
+
+// skipNumber returns the index after a numeric constant that starts at i.
+func skipNumber(sql string, i int) int {
+	for i < len(sql) {
+		switch c := sql[i]; {
+		case isDigit(c), c == '.', c == '_':
+			i++
+		case (c == 'e' || c == 'E') && i+1 < len(sql) && (isDigit(sql[i+1]) || sql[i+1] == '+' || sql[i+1] == '-'):
+			i += 2
+		default:
+			return i
+		}
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isWord(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
+
+func isOperator(c byte) bool {
+	return strings.IndexByte("+-*/<>=~!@#%^&|`?:", c) >= 0
+}
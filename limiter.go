@@ -0,0 +1,158 @@
+package pgxprom
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overflowValue replaces label values once a cardinality limit is reached.
+const overflowValue = "__overflow__"
+
+// limiter caps the number of distinct values it lets through.
+type limiter struct {
+	mu     sync.Mutex
+	limit  int
+	values map[string]struct{}
+}
+
+// newLimiter returns a limiter that lets through at most limit values.
+func newLimiter(limit int) *limiter {
+	return &limiter{
+		limit:  limit,
+		values: make(map[string]struct{}),
+	}
+}
+
+// allow reports whether the value was seen before or fits under the limit.
+func (l *limiter) allow(value string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.values[value]; ok {
+		return true
+	}
+
+	if len(l.values) >= l.limit {
+		return false
+	}
+
+	l.values[value] = struct{}{}
+	return true
+}
+
+// forget removes the value, making room for another one.
+func (l *limiter) forget(value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.values, value)
+}
+
+// retain removes the values that are not in keep.
+func (l *limiter) retain(keep map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for value := range l.values {
+		if !keep[value] {
+			delete(l.values, value)
+		}
+	}
+}
+
+// seriesLabel is the label value under which values dropped by the series
+// limit of a guard are counted.
+const seriesLabel = "__series__"
+
+// guard caps the distinct values of each label and the distinct combinations
+// of the request labels, which come first. Values beyond a limit are replaced by overflowValue
+// and counted by the dropped counter.
+type guard struct {
+	names   []string
+	labels  []*limiter
+	series  *limiter
+	dropped *prometheus.CounterVec
+}
+
+// newGuard returns a guard for the labels. A limit of zero is unlimited. The
+// dropped counter may only be nil if no limit is set.
+func newGuard(names []string, limits map[string]int, limit, seriesLimit int, dropped *prometheus.CounterVec) (*guard, error) {
+	for name := range limits {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("label limit of unknown label %q", name)
+		}
+	}
+
+	g := &guard{
+		names:   names,
+		labels:  make([]*limiter, len(names)),
+		dropped: dropped,
+	}
+
+	for index, name := range names {
+		n, ok := limits[name]
+		if !ok {
+			n = limit
+		}
+
+		if n > 0 {
+			g.labels[index] = newLimiter(n)
+		}
+	}
+
+	if seriesLimit > 0 {
+		g.series = newLimiter(seriesLimit)
+	}
+
+	return g, nil
+}
+
+// value returns the value of the label at index, or overflowValue if the
+// label has reached its limit.
+func (g *guard) value(index int, value string) string {
+	if l := g.labels[index]; l != nil && !l.allow(value) {
+		g.dropped.WithLabelValues(g.names[index]).Inc()
+		return overflowValue
+	}
+
+	return value
+}
+
+// apply replaces the values that exceed their label limit, and all of them
+// if their combination exceeds the series limit.
+func (g *guard) apply(values []string) []string {
+	for index, value := range values {
+		values[index] = g.value(index, value)
+	}
+
+	if g.series != nil && !g.series.allow(seriesKey(values)) {
+		g.dropped.WithLabelValues(seriesLabel).Inc()
+		for index := range values {
+			values[index] = overflowValue
+		}
+	}
+
+	return values
+}
+
+// forget releases the label combination from the series limit, so that it no
+// longer counts towards it.
+func (g *guard) forget(values []string) {
+	if g.series != nil {
+		g.series.forget(seriesKey(values))
+	}
+}
+
+// retain releases the values of each label limit that are not in use, so that
+// they no longer count towards it. used holds the values in use by label
+// index; labels beyond it keep their values.
+func (g *guard) retain(used []map[string]bool) {
+	for index, values := range used {
+		if l := g.labels[index]; l != nil {
+			l.retain(values)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package pgxprom
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("limiter", func() {
+	It("allows values up to the limit", func() {
+		l := newLimiter(2)
+		Expect(l.allow("a")).To(BeTrue())
+		Expect(l.allow("b")).To(BeTrue())
+		Expect(l.allow("c")).To(BeFalse())
+	})
+
+	It("keeps allowing values it has already seen", func() {
+		l := newLimiter(1)
+		Expect(l.allow("a")).To(BeTrue())
+		Expect(l.allow("b")).To(BeFalse())
+		Expect(l.allow("a")).To(BeTrue())
+	})
+})
+
+var _ = Describe("guard", func() {
+	var dropped *prometheus.CounterVec
+
+	BeforeEach(func() {
+		dropped = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dropped_total"}, []string{"label"})
+	})
+
+	It("applies the default limit to labels without their own", func() {
+		g, err := newGuard([]string{"a", "b"}, map[string]int{"b": 2}, 1, 0, dropped)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(g.apply([]string{"x", "x"})).To(Equal([]string{"x", "x"}))
+		Expect(g.apply([]string{"y", "y"})).To(Equal([]string{"__overflow__", "y"}))
+		Expect(g.apply([]string{"x", "z"})).To(Equal([]string{"x", "__overflow__"}))
+		Expect(testutil.ToFloat64(dropped.WithLabelValues("a"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(dropped.WithLabelValues("b"))).To(Equal(1.0))
+	})
+
+	It("leaves labels without a limit unchanged", func() {
+		g, err := newGuard([]string{"a"}, nil, 0, 0, dropped)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, value := range []string{"x", "y", "z"} {
+			Expect(g.apply([]string{value})).To(Equal([]string{value}))
+		}
+	})
+})
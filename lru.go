@@ -0,0 +1,67 @@
+package pgxprom
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is a cache of values keyed by SQL text that evicts the least recently
+// used entry once it is full.
+type lru[V any] struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// lruEntry is a cached value and its key.
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+// newLRU returns an empty cache of up to size entries.
+func newLRU[V any](size int) *lru[V] {
+	return &lru[V]{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns the value of the key and marks it as recently used.
+func (c *lru[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[V]).value, true
+}
+
+// add caches the value of the key, unless the key is already cached, and
+// evicts the least recently used entry if the cache is full. It returns the
+// cached value.
+func (c *lru[V]) add(key string, value V) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		return elem.Value.(*lruEntry[V]).value
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+
+	return value
+}
@@ -0,0 +1,78 @@
+package pgxprom
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// OperationNamer derives the db_operation label of a request from its context
+// and SQL. It returns an empty string when it cannot name the request.
+type OperationNamer interface {
+	Name(ctx context.Context, sql string) string
+}
+
+// OperationNamerFunc is a function that implements OperationNamer.
+type OperationNamerFunc func(ctx context.Context, sql string) string
+
+// Name implements OperationNamer.
+func (fn OperationNamerFunc) Name(ctx context.Context, sql string) string {
+	return fn(ctx, sql)
+}
+
+var (
+	commentPattern      = regexp.MustCompile(`^--\s+name:\s+(\w+)`)
+	blockCommentPattern = regexp.MustCompile(`^/\*\s*name:\s*(\w+)[^*]*\*/`)
+	queryKindPattern    = regexp.MustCompile(`^--\s+name:\s+\w+\s+:(\w+)`)
+)
+
+var (
+	// CommentNamer names a request by a leading "-- name: <Identifier>"
+	// comment, as generated by sqlc.
+	CommentNamer OperationNamer = OperationNamerFunc(func(_ context.Context, sql string) string {
+		return submatch(commentPattern, sql)
+	})
+
+	// BlockCommentNamer names a request by a leading
+	// "/* name: <Identifier> */" comment.
+	BlockCommentNamer OperationNamer = OperationNamerFunc(func(_ context.Context, sql string) string {
+		return submatch(blockCommentPattern, sql)
+	})
+
+	// ContextNamer names a request by the operation set with WithOperation.
+	ContextNamer OperationNamer = OperationNamerFunc(func(ctx context.Context, _ string) string {
+		name, _ := ctx.Value(operationKey).(string)
+		return name
+	})
+)
+
+// TrimSpaceNamer returns an OperationNamer that removes leading whitespace and
+// blank lines from the SQL before passing it to the namer.
+func TrimSpaceNamer(namer OperationNamer) OperationNamer {
+	return OperationNamerFunc(func(ctx context.Context, sql string) string {
+		return namer.Name(ctx, strings.TrimLeft(sql, " \t\r\n"))
+	})
+}
+
+// ChainNamer returns an OperationNamer that returns the first non-empty name
+// of the namers, in order.
+func ChainNamer(namers ...OperationNamer) OperationNamer {
+	return OperationNamerFunc(func(ctx context.Context, sql string) string {
+		for _, namer := range namers {
+			if name := namer.Name(ctx, sql); name != "" {
+				return name
+			}
+		}
+
+		return ""
+	})
+}
+
+// submatch returns the first submatch of the pattern in v, if any.
+func submatch(pattern *regexp.Regexp, v string) string {
+	if match := pattern.FindStringSubmatch(v); len(match) == 2 {
+		return match[1]
+	}
+
+	return ""
+}
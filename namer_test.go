@@ -0,0 +1,57 @@
+package pgxprom
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OperationNamer", func() {
+	DescribeTable("CommentNamer",
+		func(sql, expected string) {
+			Expect(CommentNamer.Name(context.Background(), sql)).To(Equal(expected))
+		},
+		Entry("line comment", "-- name: FindUser :one\nSELECT 1", "FindUser"),
+		Entry("block comment", "/* name: FindUser */ SELECT 1", ""),
+		Entry("plain SQL", "SELECT 1", ""),
+	)
+
+	DescribeTable("BlockCommentNamer",
+		func(sql, expected string) {
+			Expect(BlockCommentNamer.Name(context.Background(), sql)).To(Equal(expected))
+		},
+		Entry("block comment", "/* name: FindUser */ SELECT 1", "FindUser"),
+		Entry("block comment without spaces", "/*name:FindUser*/SELECT 1", "FindUser"),
+		Entry("block comment with a suffix", "/* name: FindUser :one */\nSELECT 1", "FindUser"),
+		Entry("line comment", "-- name: FindUser\nSELECT 1", ""),
+		Entry("unterminated block comment", "/* name: FindUser SELECT 1", ""),
+	)
+
+	It("TrimSpaceNamer skips leading whitespace and blank lines", func() {
+		namer := TrimSpaceNamer(CommentNamer)
+		Expect(namer.Name(context.Background(), "\n\n  \t-- name: FindUser\nSELECT 1")).To(Equal("FindUser"))
+	})
+
+	It("ContextNamer returns the operation set on the context", func() {
+		ctx := WithOperation(context.Background(), "ListOrders")
+		Expect(ContextNamer.Name(ctx, "SELECT 1")).To(Equal("ListOrders"))
+		Expect(ContextNamer.Name(context.Background(), "SELECT 1")).To(BeEmpty())
+	})
+
+	It("ChainNamer returns the first non-empty name", func() {
+		namer := ChainNamer(CommentNamer, BlockCommentNamer, OperationNamerFunc(func(context.Context, string) string {
+			return "fallback"
+		}))
+
+		Expect(namer.Name(context.Background(), "/* name: FindUser */ SELECT 1")).To(Equal("FindUser"))
+		Expect(namer.Name(context.Background(), "SELECT 1")).To(Equal("fallback"))
+		Expect(ChainNamer().Name(context.Background(), "SELECT 1")).To(BeEmpty())
+	})
+
+	It("is used by the QueryCollector", func() {
+		collector := newQueryCollector(WithOperationNamer(TrimSpaceNamer(BlockCommentNamer)))
+		Expect(collector.name(context.Background(), "  /* name: FindUser */ SELECT 1")).To(Equal("FindUser"))
+		Expect(collector.name(context.Background(), "-- name: FindUser\nSELECT 1")).To(Equal("unknown"))
+	})
+})
@@ -0,0 +1,639 @@
+package pgxprom
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelDatabase   = "database"
+	labelOperation  = "db_operation"
+	labelPool       = "pool"
+	labelOutcome    = "outcome"
+	labelTable      = "db_table"
+	labelHost       = "host"
+	labelErrorClass = "error_class"
+	labelConstraint = "constraint"
+	labelSQLState   = "sqlstate"
+	labelCommand    = "command"
+	labelStatement  = "db_statement"
+	labelQueryKind  = "db_query_kind"
+	labelResult     = "result"
+	labelLabel      = "label"
+	labelCollector  = "collector"
+)
+
+var (
+	namePattern  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Option configures a collector. Options that do not apply to the collector
+// being created are rejected.
+type Option func(*config) error
+
+// collectorKind identifies the collector a config is built for.
+type collectorKind int
+
+const (
+	kindPool collectorKind = iota
+	kindQuery
+)
+
+// String returns the name of the collector type.
+func (k collectorKind) String() string {
+	if k == kindPool {
+		return "PoolCollector"
+	}
+
+	return "QueryCollector"
+}
+
+// config holds the settings shared by the collectors.
+type config struct {
+	kind            collectorKind
+	namespace       string
+	subsystem       string
+	buckets         []float64
+	rowBuckets      []float64
+	nativeHistogram *NativeHistogramConfig
+	summary         *SummaryConfig
+	constLabels     prometheus.Labels
+	labelNames      map[string]string
+	poolLabels      []string
+	contextLabels   []string
+	commentLabels   []string
+	statementLabels bool
+	queryKindLabel  bool
+	namer           OperationNamer
+	classify        ErrorClassifier
+	exemplar        ExemplarExtractor
+	constraintLimit int
+	labelLimit      int
+	labelLimits     map[string]int
+	seriesLimit     int
+	seriesTTL       time.Duration
+	watchdog        *WatchdogConfig
+}
+
+// newConfig returns the config for a collector of the given kind.
+func newConfig(kind collectorKind, options ...Option) (*config, error) {
+	subsystem := "conn"
+	if kind == kindPool {
+		subsystem = "pool"
+	}
+
+	c := &config{
+		kind:            kind,
+		namespace:       "pgx",
+		subsystem:       subsystem,
+		buckets:         []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 10},
+		rowBuckets:      []float64{0, 1, 10, 100, 1000, 10000, 100000, 1000000},
+		namer:           ChainNamer(CommentNamer, ContextNamer),
+		classify:        ErrorClass,
+		constraintLimit: 100,
+	}
+
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithNamespace sets the metric namespace. The default is "pgx".
+func WithNamespace(namespace string) Option {
+	return func(c *config) error {
+		if namespace != "" && !namePattern.MatchString(namespace) {
+			return fmt.Errorf("invalid namespace %q", namespace)
+		}
+
+		c.namespace = namespace
+		return nil
+	}
+}
+
+// WithSubsystem sets the metric subsystem. The default is "conn" for the
+// QueryCollector and "pool" for the PoolCollector.
+func WithSubsystem(subsystem string) Option {
+	return func(c *config) error {
+		if subsystem != "" && !namePattern.MatchString(subsystem) {
+			return fmt.Errorf("invalid subsystem %q", subsystem)
+		}
+
+		c.subsystem = subsystem
+		return nil
+	}
+}
+
+// WithBuckets sets the buckets of the duration histograms.
+func WithBuckets(buckets ...float64) Option {
+	return func(c *config) error {
+		if err := validateBuckets(buckets); err != nil {
+			return err
+		}
+
+		c.buckets = slices.Clone(buckets)
+		return nil
+	}
+}
+
+// WithRowBuckets sets the buckets of the row count histograms.
+func WithRowBuckets(buckets ...float64) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithRowBuckets"); err != nil {
+			return err
+		}
+
+		if err := validateBuckets(buckets); err != nil {
+			return err
+		}
+
+		c.rowBuckets = slices.Clone(buckets)
+		return nil
+	}
+}
+
+// NativeHistogramConfig configures the native histogram of the request
+// duration.
+type NativeHistogramConfig struct {
+	// BucketFactor is the maximum ratio between the bounds of consecutive
+	// buckets. It must be greater than one; the default is 1.1.
+	BucketFactor float64
+	// MaxBuckets is the maximum number of buckets. When it is exceeded, the
+	// resolution is reduced. The default is 160.
+	MaxBuckets uint32
+	// Classic also exposes the classic buckets set with WithBuckets, so that
+	// both can be scraped while migrating.
+	Classic bool
+}
+
+// WithNativeHistogram records the request duration as a native histogram,
+// which has a high resolution over any range of values and requires a
+// Prometheus server with native histograms enabled.
+func WithNativeHistogram(histogram NativeHistogramConfig) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithNativeHistogram"); err != nil {
+			return err
+		}
+
+		if histogram.BucketFactor == 0 {
+			histogram.BucketFactor = 1.1
+		}
+
+		if histogram.BucketFactor <= 1 || math.IsNaN(histogram.BucketFactor) {
+			return fmt.Errorf("native histogram bucket factor must be greater than 1: %v", histogram.BucketFactor)
+		}
+
+		if histogram.MaxBuckets == 0 {
+			histogram.MaxBuckets = 160
+		}
+
+		c.nativeHistogram = &histogram
+		return nil
+	}
+}
+
+// SummaryConfig configures the summary of the request duration.
+type SummaryConfig struct {
+	// Objectives maps the quantiles to compute to their absolute error. The
+	// default is the 0.5, 0.9 and 0.99 quantiles with errors of 0.05, 0.01
+	// and 0.001.
+	Objectives map[float64]float64
+	// MaxAge is the duration for which observations are kept. The default is
+	// prometheus.DefMaxAge.
+	MaxAge time.Duration
+	// AgeBuckets is the number of buckets used to exclude observations older
+	// than MaxAge. The default is prometheus.DefAgeBuckets.
+	AgeBuckets uint32
+}
+
+// WithSummary records the request duration as a summary of precomputed
+// quantiles instead of a histogram, for backends that cannot compute
+// quantiles from buckets. Quantiles of a summary cannot be aggregated across
+// instances. It cannot be combined with WithNativeHistogram.
+func WithSummary(summary SummaryConfig) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithSummary"); err != nil {
+			return err
+		}
+
+		if summary.Objectives == nil {
+			summary.Objectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+		} else {
+			summary.Objectives = maps.Clone(summary.Objectives)
+		}
+
+		for quantile, epsilon := range summary.Objectives {
+			if quantile < 0 || quantile > 1 || math.IsNaN(quantile) {
+				return fmt.Errorf("summary quantile must be between 0 and 1: %v", quantile)
+			}
+
+			if epsilon < 0 || epsilon > 1 || math.IsNaN(epsilon) {
+				return fmt.Errorf("summary error of quantile %v must be between 0 and 1: %v", quantile, epsilon)
+			}
+		}
+
+		if summary.MaxAge < 0 {
+			return fmt.Errorf("summary max age must not be negative: %v", summary.MaxAge)
+		}
+
+		c.summary = &summary
+		return nil
+	}
+}
+
+// WithConstLabels sets labels with fixed values attached to every metric.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(c *config) error {
+		for name := range labels {
+			if err := validateLabel(name); err != nil {
+				return err
+			}
+		}
+
+		c.constLabels = maps.Clone(labels)
+		return nil
+	}
+}
+
+// WithLabelNames renames the built-in labels. The keys are the default label
+// names (for example "database", "db_operation" or "pool") and the values are
+// the names to use instead.
+func WithLabelNames(names map[string]string) Option {
+	return func(c *config) error {
+		for label, name := range names {
+			switch label {
+			case labelDatabase:
+			case labelOperation:
+				if c.kind != kindQuery {
+					return fmt.Errorf("label %q does not apply to the %s", label, c.kind)
+				}
+			case labelPool:
+				if c.kind != kindPool {
+					return fmt.Errorf("label %q does not apply to the %s", label, c.kind)
+				}
+			default:
+				return fmt.Errorf("unknown label %q", label)
+			}
+
+			if err := validateLabel(name); err != nil {
+				return err
+			}
+		}
+
+		c.labelNames = maps.Clone(names)
+		return nil
+	}
+}
+
+// WithPoolLabels declares extra labels that identify a pool, such as its role
+// or shard. The values are set per pool with PoolCollector.AddWithLabels.
+func WithPoolLabels(names ...string) Option {
+	return func(c *config) error {
+		if err := c.only(kindPool, "WithPoolLabels"); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if err := validateLabel(name); err != nil {
+				return err
+			}
+		}
+
+		c.poolLabels = slices.Clone(names)
+		return nil
+	}
+}
+
+// WithContextLabels declares extra labels of the request metrics whose values
+// are read from the context set with WithLabels. Requests without a value
+// record an empty string.
+func WithContextLabels(names ...string) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithContextLabels"); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			if err := validateLabel(name); err != nil {
+				return err
+			}
+		}
+
+		c.contextLabels = slices.Clone(names)
+		return nil
+	}
+}
+
+// WithQueryKindLabel adds the db_query_kind label to the request metrics: the
+// sqlc query annotation that follows the name in a "-- name: GetUser :one"
+// comment, without the colon ("one", "many", "exec", "copyfrom", ...). It is
+// empty when the SQL has no annotation.
+func WithQueryKindLabel() Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithQueryKindLabel"); err != nil {
+			return err
+		}
+
+		c.queryKindLabel = true
+		return nil
+	}
+}
+
+// WithSQLCommenterLabels declares extra labels of the request metrics whose
+// values are read from the tags of a trailing sqlcommenter comment in the SQL,
+// such as "route", "controller" or "action". The label names are the tag
+// keys. Requests without the tag record an empty string. High-cardinality tags
+// such as "traceparent" must not be declared.
+func WithSQLCommenterLabels(keys ...string) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithSQLCommenterLabels"); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := validateLabel(key); err != nil {
+				return err
+			}
+		}
+
+		c.commentLabels = slices.Clone(keys)
+		return nil
+	}
+}
+
+// WithStatementLabels adds the db_statement and db_table labels to the request
+// metrics: the leading verb of the SQL (for example "SELECT") and the first
+// table it reads from or writes to, in line with the OpenTelemetry
+// db.operation.name and db.collection.name attributes. They are derived by a
+// lightweight tokenizer and are empty when they cannot be determined.
+func WithStatementLabels() Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithStatementLabels"); err != nil {
+			return err
+		}
+
+		c.statementLabels = true
+		return nil
+	}
+}
+
+// WithOperationNamer sets the OperationNamer that derives the db_operation
+// label of requests. Requests it cannot name are recorded as "unknown". The
+// default is ChainNamer(CommentNamer, ContextNamer).
+func WithOperationNamer(namer OperationNamer) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithOperationNamer"); err != nil {
+			return err
+		}
+
+		if namer == nil {
+			return fmt.Errorf("operation namer must not be nil")
+		}
+
+		c.namer = namer
+		return nil
+	}
+}
+
+// WithErrorClassifier sets the function that derives the error_class label of
+// request errors. The default is ErrorClass.
+func WithErrorClassifier(classifier ErrorClassifier) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithErrorClassifier"); err != nil {
+			return err
+		}
+
+		if classifier == nil {
+			return fmt.Errorf("error classifier must not be nil")
+		}
+
+		c.classify = classifier
+		return nil
+	}
+}
+
+// WithExemplars attaches the exemplar returned by the extractor, such as the
+// ID of the current trace, to the request and batch duration histograms and
+// the error counters. Use OpenTelemetryExemplar for OpenTelemetry traces.
+// Exemplars are only exposed in the OpenMetrics format.
+func WithExemplars(extractor ExemplarExtractor) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithExemplars"); err != nil {
+			return err
+		}
+
+		if extractor == nil {
+			return fmt.Errorf("exemplar extractor must not be nil")
+		}
+
+		c.exemplar = extractor
+		return nil
+	}
+}
+
+// WithConstraintLimit sets the number of distinct constraint and table pairs
+// tracked by the constraint violations counter. Violations of further pairs
+// are counted under the "__overflow__" label value. The default is 100.
+func WithConstraintLimit(limit int) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithConstraintLimit"); err != nil {
+			return err
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("constraint limit must be positive: %d", limit)
+		}
+
+		c.constraintLimit = limit
+		return nil
+	}
+}
+
+// WithLabelLimit sets the number of distinct values recorded for each label
+// of the request metrics, the CopyFrom table and the connect host. Further
+// values are recorded as "__overflow__" and counted by
+// pgxprom_dropped_label_values_total. The remaining labels, such as
+// error_class, command and sqlstate, take values from a small fixed set, and
+// the constraint violation labels have WithConstraintLimit. The default is
+// unlimited.
+func WithLabelLimit(limit int) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithLabelLimit"); err != nil {
+			return err
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("label limit must be positive: %d", limit)
+		}
+
+		c.labelLimit = limit
+		return nil
+	}
+}
+
+// WithLabelLimits sets the number of distinct values recorded for individual
+// labels of the request metrics, the CopyFrom table ("db_table") and the
+// connect host ("host"), overriding WithLabelLimit. The keys are the
+// label names as exported, after any renaming with WithLabelNames.
+func WithLabelLimits(limits map[string]int) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithLabelLimits"); err != nil {
+			return err
+		}
+
+		for name, limit := range limits {
+			if err := validateLabel(name); err != nil {
+				return err
+			}
+
+			if limit <= 0 {
+				return fmt.Errorf("label limit of %q must be positive: %d", name, limit)
+			}
+		}
+
+		c.labelLimits = maps.Clone(limits)
+		return nil
+	}
+}
+
+// WithSeriesLimit sets the number of distinct label value combinations
+// recorded by the request metrics. Requests with further combinations are
+// recorded with every label set to "__overflow__" and counted by
+// pgxprom_dropped_label_values_total. The default is unlimited.
+func WithSeriesLimit(limit int) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithSeriesLimit"); err != nil {
+			return err
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("series limit must be positive: %d", limit)
+		}
+
+		c.seriesLimit = limit
+		return nil
+	}
+}
+
+// WithSeriesTTL deletes the series of the request metrics whose label
+// combination has not been used for the given duration, such as those of
+// short-lived databases or one-off operations, and the batch, CopyFrom,
+// prepare and constraint violation series of databases that have not been
+// used for it. Label values that are no longer used are released from the
+// label limits. A background janitor checks for expired series every half
+// TTL, and at most every millisecond; call QueryCollector.Close to stop it.
+// The default is to keep series forever.
+func WithSeriesTTL(ttl time.Duration) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithSeriesTTL"); err != nil {
+			return err
+		}
+
+		if ttl <= 0 {
+			return fmt.Errorf("series TTL must be positive: %v", ttl)
+		}
+
+		c.seriesTTL = ttl
+		return nil
+	}
+}
+
+// only returns an error unless the config is for a collector of the kind.
+func (c *config) only(kind collectorKind, option string) error {
+	if c.kind != kind {
+		return fmt.Errorf("%s does not apply to the %s", option, c.kind)
+	}
+
+	return nil
+}
+
+// fqName returns the fully-qualified name of the metric.
+func (c *config) fqName(name string) string {
+	return prometheus.BuildFQName(c.namespace, c.subsystem, name)
+}
+
+// prefix returns the namespace and subsystem that prefix the metric names,
+// joined by an underscore.
+func (c *config) prefix() string {
+	var parts []string
+
+	for _, part := range []string{c.namespace, c.subsystem} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// labels returns the configured names of the given built-in labels.
+func (c *config) labels(labels ...string) []string {
+	names := make([]string, len(labels))
+
+	for index, label := range labels {
+		if name, ok := c.labelNames[label]; ok {
+			names[index] = name
+		} else {
+			names[index] = label
+		}
+	}
+
+	return names
+}
+
+// validate reports whether the variable labels clash with each other or with
+// the const labels.
+func (c *config) validate(labels []string) error {
+	seen := make(map[string]bool, len(labels))
+
+	for _, name := range labels {
+		if seen[name] {
+			return fmt.Errorf("duplicate label %q", name)
+		}
+
+		if _, ok := c.constLabels[name]; ok {
+			return fmt.Errorf("label %q is used as a const label", name)
+		}
+
+		seen[name] = true
+	}
+
+	return nil
+}
+
+func validateBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("buckets must not be empty")
+	}
+
+	for index, bucket := range buckets {
+		if math.IsNaN(bucket) {
+			return fmt.Errorf("bucket %d is NaN", index)
+		}
+
+		if index > 0 && bucket <= buckets[index-1] {
+			return fmt.Errorf("buckets must be in increasing order: %v >= %v", buckets[index-1], bucket)
+		}
+	}
+
+	return nil
+}
+
+func validateLabel(name string) error {
+	if !labelPattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+
+	return nil
+}
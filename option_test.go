@@ -0,0 +1,134 @@
+package pgxprom
+
+import (
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Describe("Option", func() {
+	DescribeTable("accepts valid values",
+		func(option Option) {
+			_, err := newConfig(kindQuery, option)
+			Expect(err).NotTo(HaveOccurred())
+		},
+		Entry("namespace", WithNamespace("app")),
+		Entry("empty namespace", WithNamespace("")),
+		Entry("subsystem", WithSubsystem("db")),
+		Entry("buckets", WithBuckets(.0001, .001, .01)),
+		Entry("row buckets", WithRowBuckets(0, 1, 10)),
+		Entry("native histogram", WithNativeHistogram(NativeHistogramConfig{})),
+		Entry("summary", WithSummary(SummaryConfig{})),
+		Entry("const labels", WithConstLabels(prometheus.Labels{"service": "orders"})),
+		Entry("label names", WithLabelNames(map[string]string{"database": "db_name"})),
+		Entry("constraint limit", WithConstraintLimit(10)),
+		Entry("label limit", WithLabelLimit(100)),
+		Entry("label limits", WithLabelLimits(map[string]int{"db_operation": 100})),
+		Entry("series limit", WithSeriesLimit(1000)),
+		Entry("series TTL", WithSeriesTTL(time.Hour)),
+		Entry("context labels", WithContextLabels("tenant")),
+		Entry("statement labels", WithStatementLabels()),
+		Entry("query kind label", WithQueryKindLabel()),
+		Entry("sqlcommenter labels", WithSQLCommenterLabels("route", "action")),
+		Entry("watchdog", WithWatchdog(WatchdogConfig{Threshold: time.Second})),
+		Entry("watchdog with operation thresholds", WithWatchdog(WatchdogConfig{Thresholds: map[string]time.Duration{"GetUser": time.Second}})),
+	)
+
+	DescribeTable("rejects invalid values",
+		func(option Option) {
+			_, err := newConfig(kindQuery, option)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("namespace with a dash", WithNamespace("my-app")),
+		Entry("subsystem starting with a digit", WithSubsystem("1db")),
+		Entry("empty buckets", WithBuckets()),
+		Entry("unordered buckets", WithBuckets(.1, .01)),
+		Entry("duplicate buckets", WithBuckets(.1, .1)),
+		Entry("NaN bucket", WithBuckets(math.NaN())),
+		Entry("unordered row buckets", WithRowBuckets(10, 1)),
+		Entry("native histogram bucket factor of 1", WithNativeHistogram(NativeHistogramConfig{BucketFactor: 1})),
+		Entry("summary quantile above 1", WithSummary(SummaryConfig{Objectives: map[float64]float64{1.5: 0.01}})),
+		Entry("negative summary max age", WithSummary(SummaryConfig{MaxAge: -time.Minute})),
+		Entry("reserved const label", WithConstLabels(prometheus.Labels{"__name__": "x"})),
+		Entry("invalid const label", WithConstLabels(prometheus.Labels{"service-name": "x"})),
+		Entry("nil error classifier", WithErrorClassifier(nil)),
+		Entry("nil operation namer", WithOperationNamer(nil)),
+		Entry("nil exemplar extractor", WithExemplars(nil)),
+		Entry("zero constraint limit", WithConstraintLimit(0)),
+		Entry("zero label limit", WithLabelLimit(0)),
+		Entry("negative label limits", WithLabelLimits(map[string]int{"db_operation": -1})),
+		Entry("zero series limit", WithSeriesLimit(0)),
+		Entry("zero series TTL", WithSeriesTTL(0)),
+		Entry("invalid context label", WithContextLabels("tenant-id")),
+		Entry("invalid sqlcommenter label", WithSQLCommenterLabels("db.route")),
+		Entry("watchdog without threshold", WithWatchdog(WatchdogConfig{})),
+		Entry("negative watchdog interval", WithWatchdog(WatchdogConfig{Interval: -time.Second, Threshold: time.Second})),
+		Entry("negative operation threshold", WithWatchdog(WatchdogConfig{Thresholds: map[string]time.Duration{"GetUser": -time.Second}})),
+		Entry("unknown label", WithLabelNames(map[string]string{"host": "server"})),
+		Entry("invalid label name", WithLabelNames(map[string]string{"database": "db name"})),
+	)
+
+	DescribeTable("rejects options of the other collector",
+		func(kind collectorKind, option Option) {
+			_, err := newConfig(kind, option)
+			Expect(err).To(MatchError(ContainSubstring("does not apply to the " + kind.String())))
+		},
+		Entry("pool labels", kindQuery, WithPoolLabels("role")),
+		Entry("pool label name", kindQuery, WithLabelNames(map[string]string{"pool": "pool_name"})),
+		Entry("operation label name", kindPool, WithLabelNames(map[string]string{"db_operation": "op"})),
+		Entry("row buckets", kindPool, WithRowBuckets(0, 1, 10)),
+		Entry("series TTL", kindPool, WithSeriesTTL(time.Hour)),
+		Entry("label limit", kindPool, WithLabelLimit(100)),
+		Entry("watchdog", kindPool, WithWatchdog(WatchdogConfig{Threshold: time.Second})),
+	)
+
+	It("accepts the options shared by both collectors", func() {
+		for _, kind := range []collectorKind{kindPool, kindQuery} {
+			_, err := newConfig(kind,
+				WithNamespace("app"),
+				WithSubsystem("db"),
+				WithBuckets(.01, .1),
+				WithConstLabels(prometheus.Labels{"service": "orders"}),
+				WithLabelNames(map[string]string{"database": "db_name"}),
+			)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("copies the maps and slices it is given", func() {
+		labels := prometheus.Labels{"service": "orders"}
+		buckets := []float64{.01, .1}
+		thresholds := map[string]time.Duration{"GetUser": time.Second}
+
+		config, err := newConfig(kindQuery,
+			WithConstLabels(labels),
+			WithBuckets(buckets...),
+			WithWatchdog(WatchdogConfig{Thresholds: thresholds}),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		labels["service"] = "billing"
+		buckets[0] = 1
+		thresholds["GetUser"] = time.Hour
+
+		Expect(config.constLabels).To(HaveKeyWithValue("service", "orders"))
+		Expect(config.buckets).To(Equal([]float64{.01, .1}))
+		Expect(config.watchdog.Thresholds).To(HaveKeyWithValue("GetUser", time.Second))
+	})
+
+	It("applies the defaults", func() {
+		config, err := newConfig(kindQuery)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.fqName("requests_total")).To(Equal("pgx_conn_requests_total"))
+		Expect(config.labels(labelDatabase, labelOperation)).To(Equal([]string{"database", "db_operation"}))
+	})
+
+	It("rejects duplicate label names", func() {
+		config, err := newConfig(kindQuery, WithLabelNames(map[string]string{"database": "db_operation"}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.validate(config.labels(labelDatabase, labelOperation))).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,163 @@
+package pgxprom
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var _ pgx.QueryRewriter = (*SQLCommenter)(nil)
+
+// SQLCommenter is a pgx.QueryRewriter that appends a sqlcommenter comment with
+// the db_operation and the W3C traceparent of a request, so that
+// pg_stat_activity and the server logs can be correlated with the metrics.
+// Pass it as the first argument of a query:
+//
+//	rows, err := conn.Query(ctx, sql, commenter, args...)
+//
+// pgx applies only the last of several leading QueryRewriter arguments, so
+// another rewriter such as pgx.NamedArgs must be wrapped with Wrap instead of
+// being passed after it:
+//
+//	rows, err := conn.Query(ctx, sql, commenter.Wrap(pgx.NamedArgs{"id": id}))
+//
+// A traceparent makes the SQL of every request unique. Under the default
+// pgx.QueryExecModeCacheStatement each request is then prepared on the server
+// and evicts another statement from the cache, so set Traceparent only with
+// pgx.QueryExecModeExec or pgx.QueryExecModeSimpleProtocol.
+//
+// The zero value is ready to use.
+type SQLCommenter struct {
+	// Namer derives the db_operation tag. The default is
+	// ChainNamer(CommentNamer, ContextNamer); the tag is omitted when the
+	// namer returns an empty string.
+	Namer OperationNamer
+	// Traceparent returns the W3C traceparent of the context, or an empty
+	// string if there is none, such as OpenTelemetryTraceparent. The tag is
+	// omitted when it is nil, which is the default.
+	Traceparent func(ctx context.Context) string
+	// Next is a QueryRewriter, such as pgx.NamedArgs, applied before the
+	// comment is appended.
+	Next pgx.QueryRewriter
+}
+
+// Wrap returns a copy of the commenter that applies next before appending the
+// comment.
+func (s *SQLCommenter) Wrap(next pgx.QueryRewriter) *SQLCommenter {
+	commenter := *s
+	commenter.Next = next
+	return &commenter
+}
+
+// RewriteQuery implements pgx.QueryRewriter.
+func (s *SQLCommenter) RewriteQuery(ctx context.Context, conn *pgx.Conn, sql string, args []any) (string, []any, error) {
+	if s.Next != nil {
+		var err error
+		if sql, args, err = s.Next.RewriteQuery(ctx, conn, sql, args); err != nil {
+			return "", nil, err
+		}
+	}
+
+	namer := s.Namer
+	if namer == nil {
+		namer = ChainNamer(CommentNamer, ContextNamer)
+	}
+
+	tags := map[string]string{}
+	if name := namer.Name(ctx, sql); name != "" {
+		tags[labelOperation] = name
+	}
+
+	if s.Traceparent != nil {
+		if traceparent := s.Traceparent(ctx); traceparent != "" {
+			tags["traceparent"] = traceparent
+		}
+	}
+
+	return appendSQLComment(sql, tags), args, nil
+}
+
+// appendSQLComment returns the SQL with a sqlcommenter comment of the tags
+// appended. The SQL is returned unchanged when there are no tags or it already
+// ends with a comment.
+func appendSQLComment(sql string, tags map[string]string) string {
+	if len(tags) == 0 || strings.HasSuffix(strings.TrimRight(sql, " \t\r\n;"), "*/") {
+		return sql
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	pairs := make([]string, len(keys))
+	for index, key := range keys {
+		value := strings.ReplaceAll(url.PathEscape(tags[key]), "'", `\'`)
+		pairs[index] = url.PathEscape(key) + "='" + value + "'"
+	}
+
+	// a comment appended to a line that ends in a line comment would be
+	// commented out itself
+	separator := " "
+	if line := sql[strings.LastIndexByte(sql, '\n')+1:]; strings.Contains(line, "--") {
+		separator = "\n"
+	}
+
+	return sql + separator + "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+// parseSQLComment returns the tags of the trailing sqlcommenter comment of the
+// SQL, or nil if it has none.
+func parseSQLComment(sql string) map[string]string {
+	sql = strings.TrimRight(sql, " \t\r\n;")
+	if !strings.HasSuffix(sql, "*/") {
+		return nil
+	}
+
+	// the opening /* may overlap the closing */, as in /*/
+	start := strings.LastIndex(sql, "/*")
+	if start < 0 || start+2 > len(sql)-2 {
+		return nil
+	}
+
+	comment := sql[start+2 : len(sql)-2]
+	tags := map[string]string{}
+
+	for comment != "" {
+		key, rest, ok := strings.Cut(comment, "=")
+		if !ok || !strings.HasPrefix(rest, "'") {
+			return tags
+		}
+
+		// find the closing quote, skipping escaped quotes
+		end := 1
+		for end < len(rest) && (rest[end] != '\'' || rest[end-1] == '\\') {
+			end++
+		}
+
+		if end >= len(rest) {
+			return tags
+		}
+
+		value := strings.ReplaceAll(rest[1:end], `\'`, "'")
+		tags[unescape(strings.TrimSpace(key))] = unescape(value)
+
+		comment = strings.TrimPrefix(strings.TrimSpace(rest[end+1:]), ",")
+	}
+
+	return tags
+}
+
+// unescape returns the URL-decoded value, or the value itself if it is not
+// validly encoded.
+func unescape(v string) string {
+	if unescaped, err := url.PathUnescape(v); err == nil {
+		return unescaped
+	}
+
+	return v
+}
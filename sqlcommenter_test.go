@@ -0,0 +1,84 @@
+package pgxprom
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("SQLCommenter", func() {
+	DescribeTable("parseSQLComment",
+		func(sql string, expected map[string]string) {
+			Expect(parseSQLComment(sql)).To(Equal(expected))
+		},
+		Entry("tags", "SELECT 1 /*action='list',controller='orders',route='%2Forders%2F%3Aid'*/",
+			map[string]string{"action": "list", "controller": "orders", "route": "/orders/:id"}),
+		Entry("escaped quote", `SELECT 1 /*action='it\'s'*/;`, map[string]string{"action": "it's"}),
+		Entry("spaces around tags", "SELECT 1 /* action='list', route='x' */\n", map[string]string{"action": "list", "route": "x"}),
+		Entry("no comment", "SELECT 1", nil),
+		Entry("leading comment only", "/* hint */ SELECT 1", nil),
+		Entry("malformed comment", "SELECT 1 /*action=list*/", map[string]string{}),
+		Entry("overlapping comment delimiters", "SELECT 1 /*/", nil),
+		Entry("overlapping comment delimiters only", "/*/", nil),
+	)
+
+	It("appends the db_operation and traceparent tags", func() {
+		commenter := &SQLCommenter{
+			Traceparent: func(context.Context) string {
+				return "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+			},
+		}
+
+		sql, args, err := commenter.RewriteQuery(context.Background(), nil, "-- name: GetUser :one\nSELECT $1", []any{1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]any{1}))
+		Expect(sql).To(Equal("-- name: GetUser :one\nSELECT $1 /*db_operation='GetUser',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/"))
+		Expect(parseSQLComment(sql)).To(HaveKeyWithValue("db_operation", "GetUser"))
+	})
+
+	It("starts a new line after a line comment", func() {
+		sql, _, err := (&SQLCommenter{}).RewriteQuery(WithOperation(context.Background(), "Ping"), nil, "SELECT 1 -- ping", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal("SELECT 1 -- ping\n/*db_operation='Ping'*/"))
+	})
+
+	It("leaves the SQL unchanged without tags or with a trailing comment", func() {
+		commenter := &SQLCommenter{}
+
+		sql, _, err := commenter.RewriteQuery(context.Background(), nil, "SELECT 1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal("SELECT 1"))
+
+		sql, _, err = commenter.RewriteQuery(WithOperation(context.Background(), "Ping"), nil, "SELECT 1 /*action='x'*/", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sql).To(Equal("SELECT 1 /*action='x'*/"))
+	})
+
+	It("applies a wrapped query rewriter first", func() {
+		conn, server := newFakeServer("orders")
+		commenter := &SQLCommenter{}
+
+		ctx := WithOperation(context.Background(), "GetUser")
+		_, err := conn.Exec(ctx, "SELECT * FROM users WHERE id = @id",
+			pgx.QueryExecModeSimpleProtocol, commenter.Wrap(pgx.NamedArgs{"id": 7}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commenter.Next).To(BeNil())
+
+		var sql string
+		Eventually(server.queries).Should(Receive(&sql))
+		Expect(sql).To(MatchRegexp(`^SELECT \* FROM users WHERE id = +'?7'? +/\*db_operation='GetUser'\*/$`))
+	})
+
+	It("records the declared tags as labels of the QueryCollector", func() {
+		conn := newFakeConn("orders")
+		collector := newQueryCollector(WithSQLCommenterLabels("route"))
+
+		ctx := collector.TraceQueryStart(context.Background(), conn, pgx.TraceQueryStartData{SQL: "SELECT 1 /*action='list',route='%2Forders'*/"})
+		collector.TraceQueryEnd(ctx, conn, pgx.TraceQueryEndData{})
+
+		Expect(testutil.ToFloat64(collector.requestTotal.WithLabelValues("orders", "unknown", "/orders"))).To(Equal(1.0))
+	})
+})
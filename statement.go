@@ -0,0 +1,146 @@
+package pgxprom
+
+import "strings"
+
+// parseStatement returns the leading verb of the SQL in upper case, such as
+// "SELECT" or "UPDATE", and the first table it reads from or writes to. The
+// verb of a statement with common table expressions is the verb of its main
+// statement. Both are empty when they cannot be determined.
+func parseStatement(sql string) (verb, table string) {
+	tokens := lex(sql)
+
+	i := 0
+	for i < len(tokens) && tokens[i].text == "(" {
+		i++
+	}
+
+	if i < len(tokens) && tokens[i].kind == tokenWord && tokens[i].text == "with" {
+		i = skipCommonTableExpressions(tokens, i+1)
+	}
+
+	if i >= len(tokens) || tokens[i].kind != tokenWord {
+		return "", ""
+	}
+
+	verb = tokens[i].text
+	rest := tokens[i+1:]
+
+	switch verb {
+	case "select":
+		rest = after(rest, "from")
+	case "insert", "merge":
+		rest = after(rest, "into")
+	case "delete":
+		rest = after(rest, "from")
+	case "update", "copy":
+	case "truncate":
+		rest = skipWords(rest, "table")
+	default:
+		return strings.ToUpper(verb), ""
+	}
+
+	return strings.ToUpper(verb), tableName(skipWords(rest, "only"))
+}
+
+// skipCommonTableExpressions returns the index of the first token after the
+// common table expressions of a WITH clause that start at i.
+func skipCommonTableExpressions(tokens []token, i int) int {
+	if i < len(tokens) && tokens[i].text == "recursive" {
+		i++
+	}
+
+	for i < len(tokens) {
+		// skip the name, column list and AS [NOT] MATERIALIZED up to the body
+		for i < len(tokens) && !(tokens[i].text == "(" && i > 0 && tokens[i-1].kind == tokenWord && isMaterialization(tokens[i-1].text)) {
+			if tokens[i].text == "(" {
+				i = skipParens(tokens, i)
+				continue
+			}
+			i++
+		}
+
+		i = skipParens(tokens, i)
+		if i >= len(tokens) || tokens[i].text != "," {
+			return i
+		}
+		i++
+	}
+
+	return i
+}
+
+// isMaterialization reports whether the word precedes the body of a common
+// table expression.
+func isMaterialization(word string) bool {
+	return word == "as" || word == "materialized"
+}
+
+// skipParens returns the index after the parenthesis that closes the one at i.
+func skipParens(tokens []token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// after returns the tokens after the first occurrence of the keyword outside
+// of parentheses, or nil if there is none.
+func after(tokens []token, keyword string) []token {
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokenWord && t.text == keyword:
+			return tokens[i+1:]
+		}
+	}
+
+	return nil
+}
+
+// skipWords returns the tokens after the given leading words, if present.
+func skipWords(tokens []token, words ...string) []token {
+	for _, word := range words {
+		if len(tokens) > 0 && tokens[0].kind == tokenWord && tokens[0].text == word {
+			tokens = tokens[1:]
+		}
+	}
+
+	return tokens
+}
+
+// tableName returns the possibly schema-qualified name at the start of the
+// tokens, without quotes.
+func tableName(tokens []token) string {
+	var parts []string
+
+	for i := 0; i < len(tokens); i += 2 {
+		switch tokens[i].kind {
+		case tokenWord:
+			parts = append(parts, tokens[i].text)
+		case tokenQuoted:
+			parts = append(parts, strings.ReplaceAll(strings.Trim(tokens[i].text, `"`), `""`, `"`))
+		default:
+			return strings.Join(parts, ".")
+		}
+
+		if i+1 >= len(tokens) || tokens[i+1].text != "." {
+			break
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
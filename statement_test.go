@@ -0,0 +1,33 @@
+package pgxprom
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseStatement", func() {
+	DescribeTable("extracts the verb and the table",
+		func(sql, verb, table string) {
+			v, t := parseStatement(sql)
+			Expect(v).To(Equal(verb))
+			Expect(t).To(Equal(table))
+		},
+		Entry("select", "SELECT id FROM orders WHERE id = $1", "SELECT", "orders"),
+		Entry("select with a subquery in the select list", "SELECT (SELECT max(id) FROM items), name FROM orders", "SELECT", "orders"),
+		Entry("select from a subquery", "SELECT * FROM (SELECT 1) AS t", "SELECT", ""),
+		Entry("select without a table", "SELECT 1", "SELECT", ""),
+		Entry("schema-qualified table", "select * from Sales.Orders", "SELECT", "sales.orders"),
+		Entry("quoted table", `SELECT * FROM "Sales"."Orders"`, "SELECT", "Sales.Orders"),
+		Entry("insert", "INSERT INTO users (name) VALUES ($1)", "INSERT", "users"),
+		Entry("update", "UPDATE ONLY users SET name = $1", "UPDATE", "users"),
+		Entry("delete", "DELETE FROM users WHERE id = $1", "DELETE", "users"),
+		Entry("merge", "MERGE INTO stock USING delivery ON true WHEN MATCHED THEN DO NOTHING", "MERGE", "stock"),
+		Entry("truncate", "TRUNCATE TABLE users", "TRUNCATE", "users"),
+		Entry("leading comment", "-- name: GetUser :one\nSELECT * FROM users", "SELECT", "users"),
+		Entry("parenthesized select", "(SELECT * FROM a) UNION (SELECT * FROM b)", "SELECT", "a"),
+		Entry("CTE", "WITH recent AS (SELECT * FROM orders) UPDATE users SET active = true FROM recent", "UPDATE", "users"),
+		Entry("recursive CTE with columns", "WITH RECURSIVE t (n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM t), u AS NOT MATERIALIZED (SELECT 2) SELECT n FROM t", "SELECT", "t"),
+		Entry("DDL", "CREATE TABLE users (id int)", "CREATE", ""),
+		Entry("empty", "", "", ""),
+	)
+})
@@ -0,0 +1,152 @@
+package pgxprom
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+)
+
+// WatchdogConfig configures the watchdog of a QueryCollector that reports
+// requests running longer than a threshold.
+type WatchdogConfig struct {
+	// Interval is how often the running requests are scanned. The default is
+	// one second.
+	Interval time.Duration
+	// Threshold is the time after which a request is reported as stuck. Zero
+	// disables the watchdog for operations without an entry in Thresholds.
+	Threshold time.Duration
+	// Thresholds overrides Threshold per db_operation value.
+	Thresholds map[string]time.Duration
+	// Cancel asks the server to cancel a stuck request on its connection.
+	Cancel bool
+	// Logger receives a record for every stuck request. The default is
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// WithWatchdog starts a watchdog that scans the running requests of a
+// QueryCollector. A request that exceeds the threshold of its operation is
+// logged with its SQL and the PID of its connection, counted once and, when
+// Cancel is set, canceled on the server. Call QueryCollector.Close to stop it.
+func WithWatchdog(watchdog WatchdogConfig) Option {
+	return func(c *config) error {
+		if err := c.only(kindQuery, "WithWatchdog"); err != nil {
+			return err
+		}
+
+		if watchdog.Interval < 0 {
+			return fmt.Errorf("watchdog interval must not be negative: %v", watchdog.Interval)
+		}
+
+		if watchdog.Interval == 0 {
+			watchdog.Interval = time.Second
+		}
+
+		if watchdog.Threshold < 0 {
+			return fmt.Errorf("watchdog threshold must not be negative: %v", watchdog.Threshold)
+		}
+
+		enabled := watchdog.Threshold > 0
+		for operation, threshold := range watchdog.Thresholds {
+			if threshold < 0 {
+				return fmt.Errorf("watchdog threshold of %q must not be negative: %v", operation, threshold)
+			}
+
+			enabled = enabled || threshold > 0
+		}
+
+		if !enabled {
+			return fmt.Errorf("watchdog requires a positive threshold")
+		}
+
+		if watchdog.Logger == nil {
+			watchdog.Logger = slog.Default()
+		}
+
+		watchdog.Thresholds = maps.Clone(watchdog.Thresholds)
+		c.watchdog = &watchdog
+		return nil
+	}
+}
+
+// threshold returns the threshold of the operation, or zero when requests of
+// the operation are not watched.
+func (w *WatchdogConfig) threshold(operation string) time.Duration {
+	if threshold, ok := w.Thresholds[operation]; ok {
+		return threshold
+	}
+
+	return w.Threshold
+}
+
+// watch scans the running requests until the collector is closed.
+func (q *QueryCollector) watch() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.watchdog.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case now := <-ticker.C:
+			q.scan(now)
+		}
+	}
+}
+
+// scan reports the running requests that have exceeded their threshold.
+func (q *QueryCollector) scan(now time.Time) {
+	for _, r := range q.requests.all() {
+		if r.stuck {
+			continue
+		}
+
+		threshold := q.watchdog.threshold(r.labels[1])
+		if threshold <= 0 || now.Sub(r.startedAt) < threshold {
+			continue
+		}
+
+		r.stuck = true
+		q.stuckTotal.WithLabelValues(r.labels...).Inc()
+
+		pid := r.conn.PgConn().PID()
+		q.watchdog.Logger.Warn("stuck database request",
+			slog.String("database", r.labels[0]),
+			slog.String("db_operation", r.labels[1]),
+			slog.Uint64("pid", uint64(pid)),
+			slog.Duration("duration", now.Sub(r.startedAt)),
+			slog.String("sql", r.sql),
+		)
+
+		if q.watchdog.Cancel {
+			q.cancel(r)
+		}
+	}
+}
+
+// cancel asks the server to cancel the request running on the connection of r.
+// A request that has ended since the scan is not canceled, so that the cancel
+// does not hit the next request on the connection. The request may still end
+// between this check and the cancel reaching the server; the server then
+// cancels whatever the connection is running at that time, if anything.
+func (q *QueryCollector) cancel(r *request) {
+	if !q.requests.has(r) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.watchdog.Interval)
+	defer cancel()
+
+	if err := r.conn.PgConn().CancelRequest(ctx); err != nil {
+		q.watchdog.Logger.Error("cancel stuck database request",
+			slog.String("database", r.labels[0]),
+			slog.String("db_operation", r.labels[1]),
+			slog.Uint64("pid", uint64(r.conn.PgConn().PID())),
+			slog.Any("error", err),
+		)
+	}
+}